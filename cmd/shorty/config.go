@@ -5,8 +5,15 @@ import (
 	"math"
 	"net/url"
 	"os"
+	"shorty/internal/common/fetch"
+	"shorty/internal/common/scanner"
+	"shorty/internal/databases/blob"
+	"shorty/internal/services/assets"
+	"shorty/internal/services/image"
+	"shorty/internal/services/uploads"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -18,9 +25,41 @@ type Config struct {
 	LogFile     string
 	OTELUrl     string
 
+	BlobBackend blob.Backend
+	BlobPath    string
+
 	MinioEndpoint     string
 	MinioAccessKey    string
 	MinioAccessSecret string
+
+	ImageMaxWidth  int
+	ImageMaxHeight int
+	ImageMaxPixels int
+	ImageWorkers   int
+
+	ThumbnailWidth   int
+	ThumbnailQuality int
+
+	WatermarkText     string
+	WatermarkImage    string
+	WatermarkPosition image.WatermarkPosition
+	WatermarkOpacity  float64
+	WatermarkScale    float64
+
+	UploadMaxSize int
+
+	ExpirationSweepInterval time.Duration
+	PendingAssetTTL         time.Duration
+
+	MasterKeys  map[string][]byte
+	MasterKeyId string
+
+	ClamdAddress string
+	ClamdMaxSize int
+	ScanPolicy   scanner.Policy
+
+	FetchTimeout      time.Duration
+	FetchMaxRedirects int
 }
 
 func NewConfig(options ...ConfigOptions) (*Config, error) {
@@ -79,18 +118,33 @@ func NewConfig(options ...ConfigOptions) (*Config, error) {
 		return nil, fmt.Errorf("empty api key")
 	}
 
+	blobBackend := blob.BackendS3
+	if value := getenv("SHORTY_BLOB_BACKEND"); value != "" {
+		backend, err := blob.ParseBackend(value)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing blob backend: %w", err)
+		}
+		blobBackend = backend
+	}
+
+	blobPath := getenv("SHORTY_BLOB_PATH")
+	if blobBackend == blob.BackendFilesystem && blobPath == "" {
+		return nil, fmt.Errorf("empty blob path")
+	}
+
+	// minio is needed only for s3 blob backend
 	minioEndpoint := getenv("SHORTY_MINIO_ENDPOINT")
-	if minioEndpoint == "" {
+	if minioEndpoint == "" && blobBackend == blob.BackendS3 {
 		return nil, fmt.Errorf("empty minio endpoint")
 	}
 
 	minioAccessKey := getenv("SHORTY_MINIO_ACCESS_KEY")
-	if minioAccessKey == "" {
+	if minioAccessKey == "" && blobBackend == blob.BackendS3 {
 		return nil, fmt.Errorf("empty minio access key")
 	}
 
 	minioAccessSecret := getenv("SHORTY_MINIO_ACCESS_SECRET")
-	if minioAccessSecret == "" {
+	if minioAccessSecret == "" && blobBackend == blob.BackendS3 {
 		return nil, fmt.Errorf("empty minio secret")
 	}
 
@@ -106,6 +160,104 @@ func NewConfig(options ...ConfigOptions) (*Config, error) {
 		return nil, fmt.Errorf("app port is out of range")
 	}
 
+	imageMaxWidth, err := parseOptionalInt(getenv("SHORTY_IMAGE_MAX_WIDTH"), image.DefaultMaxWidth)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing image max width")
+	}
+
+	imageMaxHeight, err := parseOptionalInt(getenv("SHORTY_IMAGE_MAX_HEIGHT"), image.DefaultMaxHeight)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing image max height")
+	}
+
+	imageMaxPixels, err := parseOptionalInt(getenv("SHORTY_IMAGE_MAX_PIXELS"), image.DefaultMaxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing image max pixels")
+	}
+
+	imageWorkers, err := parseOptionalInt(getenv("SHORTY_IMAGE_WORKERS"), 1)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing image workers count")
+	}
+
+	thumbnailWidth, err := parseOptionalInt(getenv("SHORTY_THUMBNAIL_WIDTH"), image.DefaultThumbnailWidth)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing thumbnail width")
+	}
+
+	thumbnailQuality, err := parseOptionalInt(getenv("SHORTY_THUMBNAIL_QUALITY"), image.DefaultThumbnailQuality)
+	if err != nil || thumbnailQuality > 100 {
+		return nil, fmt.Errorf("error parsing thumbnail quality")
+	}
+
+	watermarkPosition := image.DefaultWatermarkPosition
+	if value := getenv("SHORTY_WATERMARK_POSITION"); value != "" {
+		watermarkPosition, err = image.ParseWatermarkPosition(value)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing watermark position: %w", err)
+		}
+	}
+
+	watermarkOpacity, err := parseOptionalFloat(getenv("SHORTY_WATERMARK_OPACITY"), image.DefaultWatermarkOpacity)
+	if err != nil || watermarkOpacity > 1 {
+		return nil, fmt.Errorf("error parsing watermark opacity")
+	}
+
+	watermarkScale, err := parseOptionalFloat(getenv("SHORTY_WATERMARK_SCALE"), image.DefaultWatermarkScale)
+	if err != nil || watermarkScale > 1 {
+		return nil, fmt.Errorf("error parsing watermark scale")
+	}
+
+	uploadMaxSize, err := parseOptionalInt(getenv("SHORTY_UPLOAD_MAX_SIZE"), uploads.DefaultMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing upload max size")
+	}
+
+	sweepInterval, err := parseOptionalInt(getenv("SHORTY_EXPIRATION_SWEEP_INTERVAL"), 600)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing expiration sweep interval")
+	}
+
+	pendingAssetTTL, err := parseOptionalInt(getenv("SHORTY_PENDING_ASSET_TTL"), int(assets.DefaultPendingTTL.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing pending asset ttl")
+	}
+
+	// assets are encrypted at rest only when master keys are set
+	masterKeys, err := assets.ParseMasterKeys(getenv("SHORTY_MASTER_KEYS"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing master keys: %w", err)
+	}
+	masterKeyId := getenv("SHORTY_MASTER_KEY_ID")
+	if len(masterKeys) > 0 && masterKeyId == "" {
+		return nil, fmt.Errorf("empty active master key id")
+	}
+
+	// uploads are scanned for malware only when clamd address is set
+	scanPolicy := scanner.FailClosed
+	if value := getenv("SHORTY_SCAN_POLICY"); value != "" {
+		scanPolicy, err = scanner.ParsePolicy(value)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing scan policy: %w", err)
+		}
+	}
+
+	// must match clamd StreamMaxLength, with fail-closed policy uploads are limited by it
+	clamdMaxSize, err := parseOptionalInt(getenv("SHORTY_CLAMD_MAX_SIZE"), scanner.DefaultMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing clamd max size")
+	}
+
+	fetchTimeout, err := parseOptionalInt(getenv("SHORTY_FETCH_TIMEOUT"), int(fetch.DefaultTimeout.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing fetch timeout")
+	}
+
+	fetchMaxRedirects, err := parseOptionalInt(getenv("SHORTY_FETCH_MAX_REDIRECTS"), fetch.DefaultMaxRedirects)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing fetch max redirects")
+	}
+
 	return &Config{
 		AppUrl:            appUrl,
 		AppPort:           uint16(appPort),
@@ -114,12 +266,71 @@ func NewConfig(options ...ConfigOptions) (*Config, error) {
 		RedisUrl:          redisUrl,
 		LogFile:           logFile,
 		OTELUrl:           otelUrl,
+		BlobBackend:       blobBackend,
+		BlobPath:          blobPath,
 		MinioEndpoint:     minioEndpoint,
 		MinioAccessKey:    minioAccessKey,
 		MinioAccessSecret: minioAccessSecret,
+		ImageMaxWidth:     imageMaxWidth,
+		ImageMaxHeight:    imageMaxHeight,
+		ImageMaxPixels:    imageMaxPixels,
+		ImageWorkers:      imageWorkers,
+		ThumbnailWidth:    thumbnailWidth,
+		ThumbnailQuality:  thumbnailQuality,
+		WatermarkText:     getenv("SHORTY_WATERMARK_TEXT"),
+		WatermarkImage:    getenv("SHORTY_WATERMARK_IMAGE"),
+		WatermarkPosition: watermarkPosition,
+		WatermarkOpacity:  watermarkOpacity,
+		WatermarkScale:    watermarkScale,
+		UploadMaxSize:     uploadMaxSize,
+
+		ExpirationSweepInterval: time.Duration(sweepInterval) * time.Second,
+		PendingAssetTTL:         time.Duration(pendingAssetTTL) * time.Second,
+
+		MasterKeys:  masterKeys,
+		MasterKeyId: masterKeyId,
+
+		ClamdAddress: getenv("SHORTY_CLAMD_ADDRESS"),
+		ClamdMaxSize: clamdMaxSize,
+		ScanPolicy:   scanPolicy,
+
+		FetchTimeout:      time.Duration(fetchTimeout) * time.Second,
+		FetchMaxRedirects: fetchMaxRedirects,
 	}, nil
 }
 
+func parseOptionalInt(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if result <= 0 {
+		return 0, fmt.Errorf("value must be positive")
+	}
+
+	return result, nil
+}
+
+func parseOptionalFloat(value string, defaultValue float64) (float64, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if result <= 0 {
+		return 0, fmt.Errorf("value must be positive")
+	}
+
+	return result, nil
+}
+
 func parseEnvFile(envFilePath string) (map[string]string, error) {
 	envFile, err := os.ReadFile(envFilePath)
 	if err != nil {
@@ -5,9 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"shorty/internal/common/fetch"
 	"shorty/internal/common/logging"
 	"shorty/internal/common/metrics"
+	"shorty/internal/common/scanner"
 	"shorty/internal/common/tracing"
+	"shorty/internal/databases/blob"
 	"shorty/internal/databases/postgres"
 	"shorty/internal/databases/redis"
 	"shorty/internal/server"
@@ -16,6 +20,10 @@ import (
 	"shorty/internal/services/guard"
 	"shorty/internal/services/image"
 	"shorty/internal/services/links"
+	"shorty/internal/services/pastes"
+	"shorty/internal/services/uploads"
+	"sync"
+	"syscall"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -29,14 +37,14 @@ func main() {
 		}
 	}()
 
-	// ctx, stop := signal.NotifyContext(context.Background(), os.Kill, os.Interrupt)
-	// defer stop()
-
-	ctx := context.Background()
+	// workers and server are stopped on shutdown signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	configOptions := []ConfigOptions{}
 
 	envFilePath := flag.String("env", "", "specifies path to .env file")
+	reencrypt := flag.Bool("reencrypt", false, "re-encrypts assets data keys with active master key and exits")
 	flag.Parse()
 
 	if *envFilePath != "" {
@@ -76,19 +84,104 @@ func main() {
 		logger.Fatal().Err(err).Msg("error connecting to redis")
 	}
 
-	s3, err := minio.New(conf.MinioEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(conf.MinioAccessKey, conf.MinioAccessSecret, ""),
-		Secure: false,
-	})
-	if err != nil {
-		logger.Fatal().Err(err).Msg("error init minio client")
+	var blobs assets.BlobStore
+	switch conf.BlobBackend {
+	case blob.BackendFilesystem:
+		blobs, err = blob.NewFilesystem(conf.BlobPath, tracer)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error init filesystem blob store")
+		}
+	case blob.BackendMemory:
+		logger.Warning().Msg("assets are stored in memory and are lost on restart")
+		blobs = blob.NewMemory()
+	default:
+		s3, err := minio.New(conf.MinioEndpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(conf.MinioAccessKey, conf.MinioAccessSecret, ""),
+			Secure: false,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error init minio client")
+		}
+		blobs = blob.NewS3(s3, tracer)
+	}
+
+	var keyring *assets.Keyring
+	if len(conf.MasterKeys) > 0 {
+		keyring, err = assets.NewKeyring(conf.MasterKeyId, conf.MasterKeys)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error init master keys")
+		}
+	}
+
+	assetsStorage := assets.NewStorage(pgdb, rdb, blobs, rdb, keyring, logger, tracer)
+	if *reencrypt {
+		if _, err := assetsStorage.ReencryptAssets(ctx); err != nil {
+			logger.Fatal().Err(err).Msg("error re-encrypting assets")
+		}
+		return
 	}
 
-	assetsStorage := assets.NewStorage(pgdb, rdb, s3, logger, tracer)
 	linksService := links.NewService(pgdb, logger, tracer, meter)
 	guardService := guard.NewService(rdb, logger, tracer, meter)
-	imageService := image.NewService(pgdb, assetsStorage, logger, tracer, meter)
-	fileService := files.NewService(pgdb, assetsStorage, logger, tracer, meter)
+	watermark := image.WatermarkConfig{
+		Text:     conf.WatermarkText,
+		Position: conf.WatermarkPosition,
+		Opacity:  conf.WatermarkOpacity,
+		Scale:    conf.WatermarkScale,
+	}
+	if conf.WatermarkImage != "" {
+		watermark.Image, err = image.LoadWatermarkImage(conf.WatermarkImage)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error loading watermark image")
+		}
+	}
+
+	var malwareScanner scanner.Scanner
+	if conf.ClamdAddress != "" {
+		malwareScanner = scanner.NewClamd(conf.ClamdAddress, scanner.DefaultTimeout)
+	}
+	scanChecker := scanner.NewChecker(malwareScanner, conf.ScanPolicy, int64(conf.ClamdMaxSize))
+
+	// uploads which can't be scanned would be rejected only after they are uploaded
+	uploadMaxSize := int64(conf.UploadMaxSize)
+	if scanMaxSize := scanChecker.MaxSize(); scanMaxSize > 0 && scanMaxSize < uploadMaxSize {
+		logger.Warning().Msgf("upload max size is limited to clamd max size %d", scanMaxSize)
+		uploadMaxSize = scanMaxSize
+	}
+
+	imageService := image.NewService(pgdb, assetsStorage, rdb, scanChecker, image.Config{
+		MaxWidth:  conf.ImageMaxWidth,
+		MaxHeight: conf.ImageMaxHeight,
+		MaxPixels: conf.ImageMaxPixels,
+
+		ThumbnailWidth:   conf.ThumbnailWidth,
+		ThumbnailQuality: conf.ThumbnailQuality,
+
+		Watermark: watermark,
+	}, logger, tracer, meter)
+	fileService := files.NewService(pgdb, assetsStorage, rdb, scanChecker, imageService, logger, tracer, meter)
+	pasteService := pastes.NewService(pgdb, assetsStorage, logger, tracer, meter)
+	uploadService := uploads.NewService(rdb, assetsStorage, fileService, uploadMaxSize, logger, tracer, meter)
+
+	workers := sync.WaitGroup{}
+	runWorker := func(run func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
+
+	hostname, _ := os.Hostname()
+	for i := range conf.ImageWorkers {
+		consumer := fmt.Sprintf("%s-%d", hostname, i)
+		runWorker(func() { imageService.RunWorker(ctx, consumer) })
+	}
+	runWorker(func() { imageService.RunStaleImagesSweeper(ctx, conf.ExpirationSweepInterval) })
+	runWorker(func() { fileService.RunPreviewWorker(ctx, hostname) })
+	runWorker(func() { assetsStorage.RunDeletionWorker(ctx, hostname) })
+	runWorker(func() { assetsStorage.RunExpirationSweeper(ctx, conf.ExpirationSweepInterval) })
+	runWorker(func() { assetsStorage.RunPendingJanitor(ctx, conf.ExpirationSweepInterval, conf.PendingAssetTTL) })
 
 	srv := server.New(server.Opts{
 		Url:          conf.AppUrl,
@@ -100,8 +193,16 @@ func main() {
 		GuardService: guardService,
 		ImageService: imageService,
 		FileService:  fileService,
+		PasteService: pasteService,
+		AssetStorage: assetsStorage,
+		Fetcher:      fetch.NewFetcher(conf.FetchTimeout, conf.FetchMaxRedirects),
+
+		UploadService: uploadService,
 	})
 	if err := srv.Run(ctx, conf.AppPort); err != nil {
 		logger.Fatal().Err(err).Msg("runing server")
 	}
+
+	// messages, which workers haven't acked, are claimed again after restart
+	workers.Wait()
 }
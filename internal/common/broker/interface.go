@@ -1,7 +1,25 @@
 package broker
 
-import "context"
+import (
+	"context"
+	"time"
+)
+
+type Message struct {
+	Id    string
+	Value string
+}
 
 type Broker interface {
 	PutFilesToDelete(ctx context.Context, name ...string) error
+	GetFilesToDelete(ctx context.Context, consumer string, count int, block time.Duration) ([]Message, error)
+	AckFilesToDelete(ctx context.Context, messageIds ...string) error
+
+	PutImagesToProcess(ctx context.Context, ids ...string) error
+	GetImagesToProcess(ctx context.Context, consumer string, count int, block time.Duration) ([]Message, error)
+	AckImagesToProcess(ctx context.Context, messageIds ...string) error
+
+	PutPreviewsToCreate(ctx context.Context, ids ...string) error
+	GetPreviewsToCreate(ctx context.Context, consumer string, count int, block time.Duration) ([]Message, error)
+	AckPreviewsToCreate(ctx context.Context, messageIds ...string) error
 }
@@ -0,0 +1,184 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"shorty/internal/common"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrUrl       = errors.New("invalid url, only http and https are allowed")
+	ErrForbidden = errors.New("url points to forbidden address")
+	ErrTooBig    = errors.New("remote file too big")
+	ErrRedirects = errors.New("too many redirects")
+	ErrFetch     = errors.New("failed fetching remote file")
+)
+
+const (
+	DefaultTimeout      = 30 * time.Second
+	DefaultMaxRedirects = 3
+
+	dialTimeout = 10 * time.Second
+	userAgent   = "Shorty/1.0"
+)
+
+// Downloads remote files for uploads. Connections are allowed only to public
+// addresses, address is checked after DNS resolution on every dial, so redirects
+// and DNS rebinding can't reach internal services
+func NewFetcher(timeout time.Duration, maxRedirects int) *Fetcher {
+	f := &Fetcher{allowAddr: isPublicAddr}
+
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !f.allowAddr(addrPort.Addr().Unmap()) {
+				return ErrForbidden
+			}
+			return nil
+		},
+	}
+
+	f.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                  nil, // proxy would dial internal addresses on our behalf
+			DialContext:            dialer.DialContext,
+			TLSHandshakeTimeout:    dialTimeout,
+			ResponseHeaderTimeout:  dialTimeout,
+			MaxResponseHeaderBytes: 64 * 1024,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return ErrRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrUrl
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+type Fetcher struct {
+	client    *http.Client
+	allowAddr func(addr netip.Addr) bool
+}
+
+// Downloaded file, it is kept in temporary file, which is removed on close
+type Remote struct {
+	*os.File
+	Name string
+	Size int64
+}
+
+func (r *Remote) Close() error {
+	err := r.File.Close()
+	os.Remove(r.File.Name())
+	return err
+}
+
+// Downloads file of at most maxSize bytes. Failures of remote server are
+// returned wrapped into ErrFetch, rejected requests return other errors
+func (f *Fetcher) Fetch(ctx context.Context, rawUrl string, maxSize int64) (*Remote, error) {
+	target, err := url.Parse(rawUrl)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return nil, ErrUrl
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, ErrUrl
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		for _, known := range []error{ErrForbidden, ErrRedirects, ErrUrl} {
+			if errors.Is(err, known) {
+				return nil, known
+			}
+		}
+		return nil, fmt.Errorf("%w: %w", ErrFetch, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: status %d", ErrFetch, resp.StatusCode)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, ErrTooBig
+	}
+
+	file, err := os.CreateTemp("", "shorty-fetch-*")
+	if err != nil {
+		return nil, err
+	}
+	remote := &Remote{File: file, Name: remoteName(resp)}
+
+	remote.Size, err = io.Copy(file, io.LimitReader(resp.Body, maxSize+1))
+	if err == nil && remote.Size > maxSize {
+		err = ErrTooBig
+	} else if err != nil {
+		err = fmt.Errorf("%w: %w", ErrFetch, err)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+
+	return remote, nil
+}
+
+// Name of remote file from Content-Disposition or last path segment of final url
+func remoteName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return common.SanitizeFileName(params["filename"])
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "/" && name != "." {
+		return common.SanitizeFileName(name)
+	}
+	return "download"
+}
+
+// Special purpose ranges, which aren't covered by netip.Addr methods
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64 may map to private IPv4
+	netip.MustParsePrefix("2002::/16"),    // 6to4 may map to private IPv4
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
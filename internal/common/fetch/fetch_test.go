@@ -0,0 +1,115 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveRemote(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/report.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("remote content"))
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../photo.jpg"`)
+		w.Write([]byte("jpeg"))
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		// no Content-Length, so size is checked while reading
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("a", 100)))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Fetcher, which allows loopback test server
+func newTestFetcher() *Fetcher {
+	f := NewFetcher(time.Second, DefaultMaxRedirects)
+	f.allowAddr = func(addr netip.Addr) bool { return addr.IsLoopback() }
+	return f
+}
+
+func TestFetch(t *testing.T) {
+	server := serveRemote(t)
+
+	remote, err := newTestFetcher().Fetch(context.Background(), server.URL+"/files/report.txt", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	content, _ := io.ReadAll(remote)
+	if remote.Name != "report.txt" || remote.Size != 14 || string(content) != "remote content" {
+		t.Fatalf("unexpected remote %s (%d bytes): %q", remote.Name, remote.Size, content)
+	}
+
+	remote, err = newTestFetcher().Fetch(context.Background(), server.URL+"/download", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	if remote.Name != "photo.jpg" {
+		t.Fatalf("expected name from Content-Disposition, got %q", remote.Name)
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	server := serveRemote(t)
+
+	if _, err := newTestFetcher().Fetch(context.Background(), server.URL+"/big", 10); err != ErrTooBig {
+		t.Fatalf("expected too big error, got %v", err)
+	}
+	if _, err := newTestFetcher().Fetch(context.Background(), server.URL+"/loop", 10); err != ErrRedirects {
+		t.Fatalf("expected redirects error, got %v", err)
+	}
+	if _, err := newTestFetcher().Fetch(context.Background(), server.URL+"/missing", 10); !errors.Is(err, ErrFetch) {
+		t.Fatalf("expected fetch error, got %v", err)
+	}
+	if _, err := newTestFetcher().Fetch(context.Background(), "file:///etc/passwd", 10); err != ErrUrl {
+		t.Fatalf("expected url error, got %v", err)
+	}
+
+	// default fetcher never connects to loopback
+	if _, err := NewFetcher(time.Second, DefaultMaxRedirects).Fetch(context.Background(), server.URL+"/files/report.txt", 1024); err != ErrForbidden {
+		t.Fatalf("expected forbidden error, got %v", err)
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	cases := map[string]bool{
+		"8.8.8.8":              true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"64:ff9b::a00:1":       false,
+		"255.255.255.255":      false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.216.34": true,
+	}
+	for value, expected := range cases {
+		if public := isPublicAddr(netip.MustParseAddr(value).Unmap()); public != expected {
+			t.Fatalf("%s: expected public=%v", value, expected)
+		}
+	}
+}
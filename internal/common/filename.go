@@ -0,0 +1,34 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MaxFileNameLen = 255
+
+// Leaves only base name without control characters, so it can't be used as path
+// or break header, name is truncated to max length on rune boundary
+func SanitizeFileName(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+
+	for len(name) > MaxFileNameLen {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" {
+		return "file"
+	}
+	return name
+}
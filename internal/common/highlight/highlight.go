@@ -0,0 +1,73 @@
+package highlight
+
+import (
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+const (
+	PlainText      = "plaintext"
+	highlightStyle = "github"
+)
+
+// Languages offered in paste form, any other language known to chroma is accepted too
+var Languages = []string{
+	PlainText, "bash", "c", "cpp", "csharp", "css", "diff", "dockerfile", "go", "html", "java",
+	"javascript", "json", "kotlin", "markdown", "php", "python", "ruby", "rust", "sql", "toml",
+	"typescript", "xml", "yaml",
+}
+
+func IsKnownLanguage(language string) bool {
+	return lexers.Get(language) != nil
+}
+
+// Detects language by file name extension, empty string is returned when nothing matches
+func LanguageByFileName(name string) string {
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		return ""
+	}
+	if config := lexer.Config(); len(config.Aliases) > 0 {
+		return config.Aliases[0]
+	}
+	return strings.ToLower(lexer.Config().Name)
+}
+
+// Detects language by content, plain text is returned when nothing matches
+func DetectLanguage(content string) string {
+	lexer := lexers.Analyse(content)
+	if lexer == nil {
+		return PlainText
+	}
+	if config := lexer.Config(); len(config.Aliases) > 0 {
+		return config.Aliases[0]
+	}
+	return strings.ToLower(lexer.Config().Name)
+}
+
+// Renders content as HTML with inline styles and line numbers,
+// content is escaped by formatter, so result is safe to embed into page
+func Highlight(content, language string) (string, error) {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return "", err
+	}
+
+	sb := strings.Builder{}
+	formatter := html.New(html.WithLineNumbers(true), html.TabWidth(4))
+	if err := formatter.Format(&sb, styles.Get(highlightStyle), iterator); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
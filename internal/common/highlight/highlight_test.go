@@ -0,0 +1,45 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLanguages(t *testing.T) {
+	for _, language := range Languages {
+		if !IsKnownLanguage(language) {
+			t.Fatalf("language %s is not known to highlighter", language)
+		}
+	}
+	if IsKnownLanguage("no-such-language") {
+		t.Fatalf("expected unknown language")
+	}
+}
+
+func TestHighlightEscapes(t *testing.T) {
+	highlighted, err := Highlight("<script>alert(1)</script>", PlainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(highlighted, "<script>") {
+		t.Fatalf("content is not escaped: %s", highlighted)
+	}
+}
+
+func TestLanguageByFileName(t *testing.T) {
+	if language := LanguageByFileName("main.go"); language != "go" {
+		t.Fatalf("expected go, got %s", language)
+	}
+	if language := LanguageByFileName("notes"); language != "" {
+		t.Fatalf("expected no language, got %s", language)
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	if language := DetectLanguage("#!/bin/bash\necho hello\n"); language != "bash" {
+		t.Fatalf("expected bash, got %s", language)
+	}
+	if language := DetectLanguage("just some words"); language != PlainText {
+		t.Fatalf("expected plain text, got %s", language)
+	}
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+const (
+	DefaultMimeType = "application/octet-stream"
+	// Max count of leading bytes considered by content sniffing
+	MimeSniffLen = 512
+)
+
+// Detects MIME type by leading bytes of content,
+// file name extension is used when content isn't recognized
+func DetectMimeType(name string, head []byte) string {
+	detected := http.DetectContentType(head)
+	if detected != DefaultMimeType {
+		return detected
+	}
+
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+		return byExt
+	}
+	return DefaultMimeType
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention periods uploaders can choose, empty value keeps upload forever
+var retentions = map[string]time.Duration{
+	"":    0,
+	"1h":  time.Hour,
+	"1d":  24 * time.Hour,
+	"1w":  7 * 24 * time.Hour,
+	"1mo": 30 * 24 * time.Hour,
+}
+
+func ParseRetention(value string) (time.Duration, error) {
+	retention, ok := retentions[value]
+	if !ok {
+		return 0, fmt.Errorf("unknown retention %q", value)
+	}
+	return retention, nil
+}
+
+// Returns expiration time for retention, nil means never expires.
+// Time is in UTC, since it is stored in timestamp without time zone
+func NewExpiresAt(retention time.Duration) *time.Time {
+	if retention <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().UTC().Add(retention)
+	return &expiresAt
+}
+
+func IsExpired(expiresAt *time.Time) bool {
+	return expiresAt != nil && !time.Now().UTC().Before(*expiresAt)
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":   0,
+		"1h": time.Hour,
+		"1w": 7 * 24 * time.Hour,
+	}
+	for value, expected := range cases {
+		retention, err := ParseRetention(value)
+		if err != nil || retention != expected {
+			t.Fatalf("retention %q: expected %s, got %s (err=%v)", value, expected, retention, err)
+		}
+	}
+
+	if _, err := ParseRetention("1y"); err == nil {
+		t.Fatalf("expected error for unknown retention")
+	}
+}
+
+func TestIsExpired(t *testing.T) {
+	past, future := time.Now().UTC().Add(-time.Minute), time.Now().UTC().Add(time.Minute)
+	if IsExpired(nil) || IsExpired(&future) || !IsExpired(&past) {
+		t.Fatalf("unexpected expiration result")
+	}
+	if NewExpiresAt(0) != nil {
+		t.Fatalf("expected no expiration for zero retention")
+	}
+}
@@ -0,0 +1,31 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// Standard antivirus test string, every scanner detects it as malware
+const EicarSignature = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// Scanner for tests, detects content containing EICAR test string. Err is returned
+// instead of scanning when set, it simulates unavailable scanner
+type Fake struct {
+	Err error
+}
+
+func (f *Fake) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(content, []byte(EicarSignature)) {
+		return &Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return &Result{}, nil
+}
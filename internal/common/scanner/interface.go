@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Default clamd StreamMaxLength, clamd drops longer streams
+const DefaultMaxSize = 25 * 1024 * 1024
+
+var ErrTooBig = errors.New("stream exceeds scanner size limit")
+
+type Result struct {
+	Infected  bool
+	Signature string
+	Scanned   bool // false when scanner is disabled or unavailable with fail-open policy
+}
+
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+}
+
+// Policy for uploads, which can't be scanned because scanner is unavailable
+type Policy string
+
+const (
+	FailOpen   Policy = "open"
+	FailClosed Policy = "closed"
+)
+
+func ParsePolicy(value string) (Policy, error) {
+	switch Policy(value) {
+	case FailOpen, FailClosed:
+		return Policy(value), nil
+	default:
+		return "", fmt.Errorf("unknown scan policy %s", value)
+	}
+}
+
+// Applies policy to scanner, nil scanner means scanning is disabled. Streams
+// longer than max size can't be scanned, they are handled like scanner is unavailable
+type Checker struct {
+	scanner Scanner
+	policy  Policy
+	maxSize int64
+}
+
+func NewChecker(scanner Scanner, policy Policy, maxSize int64) *Checker {
+	return &Checker{scanner: scanner, policy: policy, maxSize: maxSize}
+}
+
+// Largest stream accepted by checker, zero means any size
+func (c *Checker) MaxSize() int64 {
+	if c.scanner == nil || c.policy == FailOpen {
+		return 0
+	}
+	return c.maxSize
+}
+
+// Scans stream of given size from the start and rewinds it back even if scanning is
+// disabled. Scanner error is returned only with fail-closed policy
+func (c *Checker) CheckStream(ctx context.Context, r io.ReadSeeker, size int64) (*Result, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if c.scanner == nil {
+		return &Result{}, nil
+	}
+	if c.maxSize > 0 && size > c.maxSize {
+		if c.policy == FailOpen {
+			return &Result{}, nil
+		}
+		return nil, ErrTooBig
+	}
+
+	result, err := c.scanner.Scan(ctx, io.LimitReader(r, size))
+	if _, seekErr := r.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+
+	if err != nil {
+		if c.policy == FailOpen {
+			return &Result{}, nil
+		}
+		return nil, fmt.Errorf("scanner unavailable: %w", err)
+	}
+
+	result.Scanned = true
+	return result, nil
+}
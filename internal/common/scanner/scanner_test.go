@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serves single INSTREAM request, replies with FOUND when stream contains EICAR string
+func serveClamd(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		if command, _ := r.ReadString(0); command != "zINSTREAM\x00" {
+			conn.Write([]byte("UNKNOWN COMMAND ERROR\x00"))
+			return
+		}
+
+		stream := bytes.Buffer{}
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(r, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			io.CopyN(&stream, r, int64(n))
+		}
+
+		if strings.Contains(stream.String(), EicarSignature) {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClamdScan(t *testing.T) {
+	content := append(bytes.Repeat([]byte{'a'}, 3*clamdChunkSize/2), EicarSignature...)
+	result, err := NewClamd(serveClamd(t), time.Second).Scan(context.Background(), bytes.NewReader(content))
+	if err != nil || !result.Infected || result.Signature != "Eicar-Test-Signature" {
+		t.Fatalf("expected infected result, got %+v (err=%v)", result, err)
+	}
+
+	result, err = NewClamd(serveClamd(t), time.Second).Scan(context.Background(), strings.NewReader("clean"))
+	if err != nil || result.Infected {
+		t.Fatalf("expected clean result, got %+v (err=%v)", result, err)
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Fatalf("expected error reply")
+	}
+}
+
+func TestCheckerPolicy(t *testing.T) {
+	stream := strings.NewReader("some content")
+	unavailable := &Fake{Err: errors.New("connection refused")}
+
+	result, err := NewChecker(unavailable, FailOpen, DefaultMaxSize).CheckStream(context.Background(), stream, stream.Size())
+	if err != nil || result.Scanned {
+		t.Fatalf("fail-open: expected unscanned result, got %+v (err=%v)", result, err)
+	}
+	if _, err := NewChecker(unavailable, FailClosed, DefaultMaxSize).CheckStream(context.Background(), stream, stream.Size()); err == nil {
+		t.Fatalf("fail-closed: expected error")
+	}
+
+	infected := strings.NewReader(EicarSignature)
+	result, err = NewChecker(&Fake{}, FailClosed, DefaultMaxSize).CheckStream(context.Background(), infected, infected.Size())
+	if err != nil || !result.Scanned || !result.Infected {
+		t.Fatalf("expected infected result, got %+v (err=%v)", result, err)
+	}
+	if pos, _ := infected.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Fatalf("expected stream to be rewound, got position %d", pos)
+	}
+}
+
+func TestCheckerMaxSize(t *testing.T) {
+	stream := strings.NewReader(EicarSignature)
+
+	result, err := NewChecker(&Fake{}, FailOpen, 8).CheckStream(context.Background(), stream, stream.Size())
+	if err != nil || result.Scanned {
+		t.Fatalf("fail-open: expected unscanned result, got %+v (err=%v)", result, err)
+	}
+	if _, err := NewChecker(&Fake{}, FailClosed, 8).CheckStream(context.Background(), stream, stream.Size()); err != ErrTooBig {
+		t.Fatalf("fail-closed: expected too big error, got %v", err)
+	}
+	if size := NewChecker(&Fake{}, FailClosed, 8).MaxSize(); size != 8 {
+		t.Fatalf("fail-closed: expected max size 8, got %d", size)
+	}
+	if size := NewChecker(nil, FailClosed, 8).MaxSize(); size != 0 {
+		t.Fatalf("disabled: expected no max size, got %d", size)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"math/rand"
 	"regexp"
 	"strings"
@@ -36,6 +37,15 @@ func NewAssetHash(asset []byte) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// Hasher for streamed assets, result matches NewAssetHash
+func NewAssetHasher() hash.Hash {
+	return sha512.New()
+}
+
+func AssetHasherSum(hasher hash.Hash) string {
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
 func ValidateShortId(value string) bool {
 	return shortIdRegexp.MatchString(value)
 }
@@ -0,0 +1,26 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Backend of blob store, selected by config
+type Backend string
+
+const (
+	BackendS3         Backend = "s3"
+	BackendFilesystem Backend = "fs"
+	BackendMemory     Backend = "memory"
+)
+
+func ParseBackend(value string) (Backend, error) {
+	switch Backend(value) {
+	case BackendS3, BackendFilesystem, BackendMemory:
+		return Backend(value), nil
+	default:
+		return "", fmt.Errorf("unknown blob backend %s", value)
+	}
+}
@@ -0,0 +1,116 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"shorty/internal/services/assets"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func testStores(t *testing.T) map[string]assets.BlobStore {
+	fs, err := NewFilesystem(t.TempDir(), noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]assets.BlobStore{"fs": fs, "memory": NewMemory()}
+}
+
+func readFile(t *testing.T, s assets.BlobStore, bucket, id string) string {
+	t.Helper()
+	body, err := s.GetFile(context.Background(), bucket, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	content, _ := io.ReadAll(body)
+	return string(content)
+}
+
+func TestSaveFile(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		if err := s.SaveFile(ctx, "files", "abcdef", strings.NewReader("content"), 7); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if content := readFile(t, s, "files", "abcdef"); content != "content" {
+			t.Fatalf("%s: unexpected content %q", name, content)
+		}
+
+		if err := s.SaveFile(ctx, "files", "short", strings.NewReader("abc"), 7); err == nil {
+			t.Fatalf("%s: expected error for short stream", name)
+		}
+
+		if err := s.RemoveFile(ctx, "files", "abcdef"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := s.GetFile(ctx, "files", "abcdef"); err != ErrNotFound {
+			t.Fatalf("%s: expected not found, got %v", name, err)
+		}
+		if err := s.RemoveFile(ctx, "files", "abcdef"); err != nil {
+			t.Fatalf("%s: removing missing file: %v", name, err)
+		}
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		uploadId, err := s.NewMultipart(ctx, "files", "object")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// parts are ordered by number, not by upload order
+		for number, part := range map[int]string{2: "world", 1: "hello ", 10: "!"} {
+			if err := s.PutPart(ctx, "files", "object", uploadId, number, strings.NewReader(part), int64(len(part))); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if err := s.CompleteMultipart(ctx, "files", "object", uploadId); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if content := readFile(t, s, "files", "object"); content != "hello world!" {
+			t.Fatalf("%s: unexpected content %q", name, content)
+		}
+
+		uploadId, _ = s.NewMultipart(ctx, "files", "aborted")
+		s.PutPart(ctx, "files", "aborted", uploadId, 1, bytes.NewReader([]byte("part")), 4)
+		if err := s.AbortMultipart(ctx, "files", "aborted", uploadId); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := s.CompleteMultipart(ctx, "files", "aborted", uploadId); err != ErrNotFound {
+			t.Fatalf("%s: expected not found for aborted upload, got %v", name, err)
+		}
+	}
+}
+
+func TestFilesystemLayout(t *testing.T) {
+	root := t.TempDir()
+	fs, _ := NewFilesystem(root, noop.NewTracerProvider().Tracer("test"))
+	ctx := context.Background()
+
+	if err := fs.SaveFile(ctx, "files", "abcdef", strings.NewReader("content"), 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "files", "ab", "cd", "abcdef")); err != nil {
+		t.Fatalf("expected sharded object path: %v", err)
+	}
+
+	// failed write leaves no temporary files
+	fs.SaveFile(ctx, "files", "abcxyz", strings.NewReader("abc"), 7)
+	entries, _ := os.ReadDir(filepath.Join(root, "files", "ab", "cx"))
+	if len(entries) != 0 {
+		t.Fatalf("expected no leftovers, got %d entries", len(entries))
+	}
+
+	for _, id := range []string{"../escape", "a/b", ""} {
+		if err := fs.SaveFile(ctx, "files", id, strings.NewReader(""), 0); err == nil {
+			t.Fatalf("expected error for id %q", id)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"shorty/internal/common"
+	"strconv"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tempPrefix   = ".tmp-"
+	multipartDir = ".multipart"
+)
+
+// Bucket names, object and upload ids, they are used as path elements
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Blob store in local directory. Objects are sharded into subdirectories by id prefix,
+// so directories stay small, and written to temporary file renamed into place, so
+// readers never see partially written object
+func NewFilesystem(root string, tracer trace.Tracer) (*Filesystem, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating blob store directory: %w", err)
+	}
+	return &Filesystem{root: root, tracer: tracer}, nil
+}
+
+type Filesystem struct {
+	root   string
+	tracer trace.Tracer
+}
+
+func (f *Filesystem) objectPath(bucket, id string) (string, error) {
+	if !nameRegexp.MatchString(bucket) || !nameRegexp.MatchString(id) {
+		return "", fmt.Errorf("invalid blob name %s/%s", bucket, id)
+	}
+	shard := (id + "____")[:4]
+	return filepath.Join(f.root, bucket, shard[:2], shard[2:], id), nil
+}
+
+func (f *Filesystem) uploadPath(bucket, uploadId string) (string, error) {
+	if !nameRegexp.MatchString(bucket) || !nameRegexp.MatchString(uploadId) {
+		return "", fmt.Errorf("invalid upload name %s/%s", bucket, uploadId)
+	}
+	return filepath.Join(f.root, bucket, multipartDir, uploadId), nil
+}
+
+func (f *Filesystem) SaveFile(ctx context.Context, bucket, id string, r io.Reader, size int64) error {
+	_, span := f.tracer.Start(ctx, "fs::SaveFile")
+	defer span.End()
+
+	path, err := f.objectPath(bucket, id)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, r, size)
+}
+
+// Returned file must be closed by caller
+func (f *Filesystem) GetFile(ctx context.Context, bucket, id string) (io.ReadSeekCloser, error) {
+	_, span := f.tracer.Start(ctx, "fs::GetFile")
+	defer span.End()
+
+	path, err := f.objectPath(bucket, id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Removing missing object isn't an error, like in S3
+func (f *Filesystem) RemoveFile(ctx context.Context, bucket, id string) error {
+	_, span := f.tracer.Start(ctx, "fs::RemoveFile")
+	defer span.End()
+
+	path, err := f.objectPath(bucket, id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Parts of multipart upload are kept in upload directory until completion
+func (f *Filesystem) NewMultipart(ctx context.Context, bucket, id string) (string, error) {
+	_, span := f.tracer.Start(ctx, "fs::NewMultipart")
+	defer span.End()
+
+	uploadId := common.NewShortId(32)
+	path, err := f.uploadPath(bucket, uploadId)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(path, 0o750); err != nil {
+		return "", err
+	}
+	return uploadId, nil
+}
+
+func (f *Filesystem) PutPart(ctx context.Context, bucket, id, uploadId string, partNumber int, r io.Reader, size int64) error {
+	_, span := f.tracer.Start(ctx, "fs::PutPart")
+	defer span.End()
+
+	path, err := f.uploadPath(bucket, uploadId)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return writeAtomic(filepath.Join(path, strconv.Itoa(partNumber)), r, size)
+}
+
+// Concatenates parts ordered by number into object and removes upload directory
+func (f *Filesystem) CompleteMultipart(ctx context.Context, bucket, id, uploadId string) error {
+	_, span := f.tracer.Start(ctx, "fs::CompleteMultipart")
+	defer span.End()
+
+	uploadPath, err := f.uploadPath(bucket, uploadId)
+	if err != nil {
+		return err
+	}
+	objectPath, err := f.objectPath(bucket, id)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(uploadPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	parts := map[int]string{}
+	for _, entry := range entries {
+		// temporary files of unfinished writes aren't numbers
+		number, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		parts[number] = filepath.Join(uploadPath, entry.Name())
+	}
+
+	readers := []io.Reader{}
+	for _, number := range sortedParts(parts) {
+		part, err := os.Open(parts[number])
+		if err != nil {
+			return err
+		}
+		defer part.Close()
+		readers = append(readers, part)
+	}
+
+	if err := writeAtomic(objectPath, io.MultiReader(readers...), -1); err != nil {
+		return err
+	}
+	return os.RemoveAll(uploadPath)
+}
+
+func (f *Filesystem) AbortMultipart(ctx context.Context, bucket, id, uploadId string) error {
+	_, span := f.tracer.Start(ctx, "fs::AbortMultipart")
+	defer span.End()
+
+	path, err := f.uploadPath(bucket, uploadId)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// Writes exactly size bytes into temporary file in target directory and renames it
+// to path, rename is atomic within filesystem. Negative size means unknown size
+func writeAtomic(path string, r io.Reader, size int64) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	// no-op after successful rename
+	defer os.Remove(tmp.Name())
+
+	if size < 0 {
+		_, err = io.Copy(tmp, r)
+	} else {
+		_, err = io.CopyN(tmp, r, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
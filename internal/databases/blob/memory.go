@@ -0,0 +1,141 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"shorty/internal/common"
+	"slices"
+	"sync"
+)
+
+// Blob store kept in process memory, for tests and development only, content is lost on restart
+func NewMemory() *Memory {
+	return &Memory{
+		objects: map[string][]byte{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+type Memory struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+}
+
+type memoryBody struct {
+	*bytes.Reader
+}
+
+func (memoryBody) Close() error {
+	return nil
+}
+
+func objectKey(bucket, id string) string {
+	return bucket + "/" + id
+}
+
+func (m *Memory) SaveFile(ctx context.Context, bucket, id string, r io.Reader, size int64) error {
+	content, err := readObject(r, size)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.objects[objectKey(bucket, id)] = content
+	return nil
+}
+
+func (m *Memory) GetFile(ctx context.Context, bucket, id string) (io.ReadSeekCloser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	content, ok := m.objects[objectKey(bucket, id)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// saved content is never modified, so it is shared with reader
+	return memoryBody{bytes.NewReader(content)}, nil
+}
+
+func (m *Memory) RemoveFile(ctx context.Context, bucket, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.objects, objectKey(bucket, id))
+	return nil
+}
+
+func (m *Memory) NewMultipart(ctx context.Context, bucket, id string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	uploadId := common.NewShortId(32)
+	m.uploads[uploadId] = map[int][]byte{}
+	return uploadId, nil
+}
+
+func (m *Memory) PutPart(ctx context.Context, bucket, id, uploadId string, partNumber int, r io.Reader, size int64) error {
+	content, err := readObject(r, size)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	parts, ok := m.uploads[uploadId]
+	if !ok {
+		return ErrNotFound
+	}
+	parts[partNumber] = content
+	return nil
+}
+
+func (m *Memory) CompleteMultipart(ctx context.Context, bucket, id, uploadId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	parts, ok := m.uploads[uploadId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	content := []byte{}
+	for _, number := range sortedParts(parts) {
+		content = append(content, parts[number]...)
+	}
+	m.objects[objectKey(bucket, id)] = content
+	delete(m.uploads, uploadId)
+	return nil
+}
+
+func (m *Memory) AbortMultipart(ctx context.Context, bucket, id, uploadId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.uploads, uploadId)
+	return nil
+}
+
+// Reads exactly size bytes like S3 does, negative size means unknown size
+func readObject(r io.Reader, size int64) ([]byte, error) {
+	if size < 0 {
+		return io.ReadAll(r)
+	}
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func sortedParts[T any](parts map[int]T) []int {
+	numbers := make([]int, 0, len(parts))
+	for number := range parts {
+		numbers = append(numbers, number)
+	}
+	slices.Sort(numbers)
+	return numbers
+}
@@ -0,0 +1,112 @@
+package blob
+
+import (
+	"context"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func NewS3(s3 *minio.Client, tracer trace.Tracer) *S3 {
+	return &S3{
+		s3:     s3,
+		tracer: tracer,
+	}
+}
+
+// Blob store backed by S3 compatible storage, buckets must be created in advance
+type S3 struct {
+	s3     *minio.Client
+	tracer trace.Tracer
+}
+
+func (f *S3) SaveFile(ctx context.Context, bucket, id string, r io.Reader, size int64) error {
+	_, span := f.tracer.Start(ctx, "s3::SaveFile")
+	defer span.End()
+
+	opts := minio.PutObjectOptions{} //ContentType: "image/jpeg"}
+	_, err := f.s3.PutObject(ctx, bucket, id, r, size, opts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returned object is read lazily, it must be closed by caller
+func (f *S3) GetFile(ctx context.Context, bucket, id string) (io.ReadSeekCloser, error) {
+	_, span := f.tracer.Start(ctx, "s3::GetFile")
+	defer span.End()
+
+	//TODO: return err only when db access fails
+	obj, err := f.s3.GetObject(ctx, bucket, id, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// object request is lazy, stat it so missing objects fail before response is started
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (f *S3) RemoveFile(ctx context.Context, bucket, id string) error {
+	_, span := f.tracer.Start(ctx, "s3::RemoveFile")
+	defer span.End()
+
+	return f.s3.RemoveObject(ctx, bucket, id, minio.RemoveObjectOptions{})
+}
+
+func (f *S3) core() minio.Core {
+	return minio.Core{Client: f.s3}
+}
+
+func (f *S3) NewMultipart(ctx context.Context, bucket, id string) (string, error) {
+	_, span := f.tracer.Start(ctx, "s3::NewMultipart")
+	defer span.End()
+
+	return f.core().NewMultipartUpload(ctx, bucket, id, minio.PutObjectOptions{})
+}
+
+func (f *S3) PutPart(ctx context.Context, bucket, id, uploadId string, partNumber int, r io.Reader, size int64) error {
+	_, span := f.tracer.Start(ctx, "s3::PutPart")
+	defer span.End()
+
+	_, err := f.core().PutObjectPart(ctx, bucket, id, uploadId, partNumber, r, size, minio.PutObjectPartOptions{})
+	return err
+}
+
+// Completes multipart upload with all parts uploaded so far
+func (f *S3) CompleteMultipart(ctx context.Context, bucket, id, uploadId string) error {
+	_, span := f.tracer.Start(ctx, "s3::CompleteMultipart")
+	defer span.End()
+
+	parts := []minio.CompletePart{}
+	marker := 0
+	for {
+		result, err := f.core().ListObjectParts(ctx, bucket, id, uploadId, marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	_, err := f.core().CompleteMultipartUpload(ctx, bucket, id, uploadId, parts, minio.PutObjectOptions{})
+	return err
+}
+
+func (f *S3) AbortMultipart(ctx context.Context, bucket, id, uploadId string) error {
+	_, span := f.tracer.Start(ctx, "s3::AbortMultipart")
+	defer span.End()
+
+	return f.core().AbortMultipartUpload(ctx, bucket, id, uploadId)
+}
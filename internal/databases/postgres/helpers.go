@@ -51,3 +51,39 @@ func exec(ctx context.Context, p *Postgres, funcName, query string, arguments ..
 	}
 	return err
 }
+
+func queryRows[T any](
+	ctx context.Context,
+	p *Postgres,
+	funcName string,
+	scanFunc func(row pgx.Row) (T, error),
+	query string, arguments ...any,
+) ([]T, error) {
+	defer observe(ctx, p, funcName)()
+
+	rows, err := p.db.Query(ctx, query, arguments...)
+	if err != nil {
+		p.logger.WithContext(ctx).Error().Err(err).Str("func", funcName).Msg("failed exec db query")
+		return nil, err
+	}
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (T, error) {
+		return scanFunc(row)
+	})
+	if err != nil {
+		p.logger.WithContext(ctx).Error().Err(err).Str("func", funcName).Msg("failed scanning db rows")
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func transaction(ctx context.Context, p *Postgres, funcName string, txFunc func(tx pgx.Tx) error) error {
+	defer observe(ctx, p, funcName)()
+
+	err := pgx.BeginFunc(ctx, p.db, txFunc)
+	if err != nil {
+		p.logger.WithContext(ctx).Error().Err(err).Str("func", funcName).Msg("failed exec db transaction")
+	}
+	return err
+}
@@ -8,7 +8,8 @@ import (
 	"shorty/internal/services/assets"
 	"shorty/internal/services/files"
 	"shorty/internal/services/image"
-	"strings"
+	"shorty/internal/services/pastes"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -42,40 +43,197 @@ type Postgres struct {
 	latencyHist metrics.Histogram
 }
 
-func (p *Postgres) SaveImageMetadata(ctx context.Context, meta image.ImageMetadataDTO) error {
-	query := `INSERT INTO images (id, name, original_id, thumbnail_id) VALUES ($1, $2, $3, $4);`
-	return exec(ctx, p, "SaveImageMetadata", query,
-		meta.Id, meta.Name, meta.OriginalId, meta.ThumbnailId)
+// Saves image only when all its assets are created, they are locked for share, so they
+// can't be deleted meanwhile. Returns false, when some asset is already deleted
+func (p *Postgres) SaveImageMetadata(ctx context.Context, meta image.ImageMetadataDTO) (bool, error) {
+	scanFunc := func(row pgx.Row) (bool, error) {
+		saved := ""
+		err := row.Scan(&saved)
+		return err == nil, err
+	}
+
+	assetIds := []string{meta.OriginalId}
+	for _, id := range []string{meta.ThumbnailId, meta.WatermarkedId} {
+		if id != "" {
+			assetIds = append(assetIds, id)
+		}
+	}
+
+	query := `INSERT INTO images (id, name, original_id, thumbnail_id, source_id, watermark, watermarked_id,
+			width, height, format, placeholder, status, expires_at)
+		SELECT $1, $2, $3, nullif($4, ''), nullif($5, ''), $6, nullif($7, ''), nullif($8, 0), nullif($9, 0), $10, $11, $12, $13
+		WHERE (SELECT count(*) FROM (
+			SELECT 1 FROM assets WHERE id = any($14::text[]) AND status = 'created' FOR SHARE
+		) locked) = cardinality($14::text[])
+		RETURNING id;`
+	return queryRow(ctx, p, "SaveImageMetadata", scanFunc, query,
+		meta.Id, meta.Name, meta.OriginalId, meta.ThumbnailId, meta.SourceId, meta.Watermark, meta.WatermarkedId,
+		meta.Width, meta.Height, meta.Format, meta.Placeholder, meta.Status, meta.ExpiresAt, assetIds)
+}
+
+// Sets processed assets of image, which is still processing.
+// Returns false when image is already processed by another worker or failed
+func (p *Postgres) SetImageProcessed(ctx context.Context, id, thumbnailId, watermarkedId, placeholder string) (bool, error) {
+	scanFunc := func(row pgx.Row) (bool, error) {
+		updated := ""
+		err := row.Scan(&updated)
+		return err == nil, err
+	}
+
+	query := `UPDATE images 
+		SET thumbnail_id = $2, watermarked_id = nullif($3, ''), placeholder = $4, status = 'ready', updated_at = now()
+		WHERE id = $1 AND status = 'processing'
+		RETURNING id;`
+	return queryRow(ctx, p, "SetImageProcessed", scanFunc, query, id, thumbnailId, watermarkedId, placeholder)
+}
+
+func (p *Postgres) SetImageFailed(ctx context.Context, id string) error {
+	query := `UPDATE images SET status = 'failed', updated_at = now() WHERE id = $1 AND status = 'processing';`
+	return exec(ctx, p, "SetImageFailed", query, id)
+}
+
+// Returns images processing longer than given time and touches them,
+// so they aren't claimed again until next period
+func (p *Postgres) ClaimStaleImages(ctx context.Context, olderThan time.Duration, limit int) ([]string, error) {
+	scanFunc := func(row pgx.Row) (string, error) {
+		id := ""
+		err := row.Scan(&id)
+		return id, err
+	}
+
+	query := `UPDATE images SET updated_at = now()
+		WHERE id IN (
+			SELECT id FROM images
+			WHERE status = 'processing' AND updated_at < now() - make_interval(secs => $1)
+			ORDER BY updated_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id;`
+	return queryRows(ctx, p, "ClaimStaleImages", scanFunc, query, olderThan.Seconds(), limit)
 }
 
-func (p *Postgres) GetImageMetadataDuplicate(ctx context.Context, size int, hash string) (*image.ImageMetadataExDTO, error) {
+// Returns image with same content and watermark option, its assets are reused as is
+func (p *Postgres) GetImageMetadataDuplicate(ctx context.Context, size int, hash string, watermark bool) (*image.ImageMetadataExDTO, error) {
 	scanFunc := func(row pgx.Row) (*image.ImageMetadataExDTO, error) {
 		r := &image.ImageMetadataExDTO{Size: size, Hash: hash}
-		return r, row.Scan(&r.Id, &r.Name, &r.OriginalId, &r.OriginalResourceId, &r.ThumbnailId, &r.ThumbnailResourceId)
+		return r, row.Scan(&r.Id, &r.Name, &r.OriginalId, &r.OriginalResourceId, &r.OriginalScanned, &r.ThumbnailId, &r.ThumbnailResourceId,
+			&r.SourceId, &r.Watermark, &r.WatermarkedId, &r.Width, &r.Height, &r.Format, &r.Placeholder, &r.Status, &r.ExpiresAt)
 	}
 
-	query := `SELECT i.id, i.name, ao.id, ao.resource_id, at.id, at.resource_id
+	query := `SELECT i.id, i.name, ao.id, ao.resource_id, ao.scanned, coalesce(at.id, ''), coalesce(at.resource_id, ''),
+			coalesce(i.source_id, ''), i.watermark, coalesce(i.watermarked_id, ''), coalesce(i.width, 0), coalesce(i.height, 0), i.format, i.placeholder, i.status,
+			i.expires_at
 		FROM images i
 		JOIN assets ao ON ao.id = i.original_id
-		JOIN assets at ON at.id = i.thumbnail_id
-		WHERE ao.hash = $1 AND ao.size = $2;`
-	return queryRow(ctx, p, "GetImageMetadataDuplicate", scanFunc, query, hash, size)
+		LEFT JOIN assets at ON at.id = i.thumbnail_id
+		WHERE ao.hash = $1 AND ao.size = $2 AND ao.status = 'created' AND i.status <> 'failed' AND i.watermark = $3
+			AND (i.expires_at IS NULL OR i.expires_at > now() AT TIME ZONE 'utc')
+		ORDER BY i.status DESC
+		LIMIT 1;`
+	return queryRow(ctx, p, "GetImageMetadataDuplicate", scanFunc, query, hash, size, watermark)
 }
 
 func (p *Postgres) GetImageMetadataById(ctx context.Context, id string) (*image.ImageMetadataExDTO, error) {
 	scanFunc := func(row pgx.Row) (*image.ImageMetadataExDTO, error) {
 		r := &image.ImageMetadataExDTO{Id: id}
-		return r, row.Scan(&r.Size, &r.Name, &r.Hash, &r.OriginalId, &r.OriginalResourceId, &r.ThumbnailId, &r.ThumbnailResourceId)
+		return r, row.Scan(&r.Size, &r.Name, &r.Hash, &r.OriginalId, &r.OriginalResourceId, &r.ThumbnailId, &r.ThumbnailResourceId,
+			&r.SourceId, &r.Watermark, &r.WatermarkedId, &r.Width, &r.Height, &r.Format, &r.Placeholder, &r.Status, &r.ExpiresAt)
 	}
 
-	query := `SELECT ao.size, i.name, ao.hash, ao.id, ao.resource_id, at.id, at.resource_id 
+	query := `SELECT ao.size, i.name, ao.hash, ao.id, ao.resource_id, coalesce(at.id, ''), coalesce(at.resource_id, ''),
+			coalesce(i.source_id, ''), i.watermark, coalesce(i.watermarked_id, ''), coalesce(i.width, 0), coalesce(i.height, 0), i.format, i.placeholder, i.status,
+			i.expires_at
 		FROM images i
 		JOIN assets ao ON ao.id = i.original_id
-		JOIN assets at ON at.id = i.thumbnail_id
+		LEFT JOIN assets at ON at.id = i.thumbnail_id
 		WHERE i.id = $1;`
 	return queryRow(ctx, p, "GetImageMetadataById", scanFunc, query, id)
 }
 
+// Saves variant unless it's already saved and returns asset of stored variant,
+// it differs from given one when variant is saved concurrently
+func (p *Postgres) SaveImageVariant(ctx context.Context, variant image.ImageVariantDTO) (string, error) {
+	scanFunc := func(row pgx.Row) (string, error) {
+		assetId := ""
+		err := row.Scan(&assetId)
+		return assetId, err
+	}
+
+	// no-op update locks and returns conflicting row, which do nothing doesn't return
+	query := `INSERT INTO image_variants (source_id, format, asset_id) VALUES ($1, $2, $3)
+		ON CONFLICT (source_id, format) DO UPDATE SET asset_id = image_variants.asset_id
+		RETURNING asset_id;`
+	return queryRow(ctx, p, "SaveImageVariant", scanFunc, query, variant.SourceId, variant.Format, variant.AssetId)
+}
+
+func (p *Postgres) GetImageVariant(ctx context.Context, sourceId string, format image.Format) (*image.ImageVariantDTO, error) {
+	scanFunc := func(row pgx.Row) (*image.ImageVariantDTO, error) {
+		r := &image.ImageVariantDTO{SourceId: sourceId, Format: format}
+		return r, row.Scan(&r.AssetId)
+	}
+
+	query := `SELECT asset_id FROM image_variants WHERE source_id = $1 AND format = $2;`
+	return queryRow(ctx, p, "GetImageVariant", scanFunc, query, sourceId, format)
+}
+
+func (p *Postgres) CountThumbnails(ctx context.Context, createdBefore time.Time) (int, error) {
+	scanFunc := func(row pgx.Row) (int, error) {
+		count := 0
+		err := row.Scan(&count)
+		return count, err
+	}
+
+	query := `SELECT count(DISTINCT i.thumbnail_id)
+		FROM images i
+		JOIN assets t ON t.id = i.thumbnail_id
+		WHERE i.status = 'ready' AND t.created_at::timestamptz < $1
+			AND (i.expires_at IS NULL OR i.expires_at > now() AT TIME ZONE 'utc');`
+	return queryRow(ctx, p, "CountThumbnails", scanFunc, query, createdBefore)
+}
+
+func (p *Postgres) GetThumbnailsBatch(ctx context.Context, after string, createdBefore time.Time, limit int) ([]image.ThumbnailSourceDTO, error) {
+	scanFunc := func(row pgx.Row) (image.ThumbnailSourceDTO, error) {
+		r := image.ThumbnailSourceDTO{}
+		err := row.Scan(&r.ThumbnailId, &r.SourceId)
+		return r, err
+	}
+
+	query := `SELECT DISTINCT ON (i.thumbnail_id) i.thumbnail_id, coalesce(i.watermarked_id, i.original_id)
+		FROM images i
+		JOIN assets t ON t.id = i.thumbnail_id
+		WHERE i.status = 'ready' AND i.thumbnail_id > $1 AND t.created_at::timestamptz < $2
+			AND (i.expires_at IS NULL OR i.expires_at > now() AT TIME ZONE 'utc')
+		ORDER BY i.thumbnail_id
+		LIMIT $3;`
+	return queryRows(ctx, p, "GetThumbnailsBatch", scanFunc, query, after, createdBefore, limit)
+}
+
+// Replaces thumbnail for all images sharing it, returns ids of old thumbnail and its variants,
+// or nothing when no image has old thumbnail anymore. They aren't deleted here, since
+// deduplicated assets may be referenced by other records
+func (p *Postgres) SwapThumbnail(ctx context.Context, oldId, newId string) ([]string, error) {
+	var released []string
+	err := transaction(ctx, p, "SwapThumbnail", func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE images SET thumbnail_id = $2, updated_at = now() WHERE thumbnail_id = $1;`, oldId, newId)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, `SELECT asset_id FROM image_variants WHERE source_id = $1;`, oldId)
+		if err != nil {
+			return err
+		}
+		variantIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+		released = append([]string{oldId}, variantIds...)
+		return nil
+	})
+	return released, err
+}
+
 func (p *Postgres) SaveShortlink(ctx context.Context, id, url string) error {
 	query := `insert into shortlinks(id, url) values($1, $2);`
 	return exec(ctx, p, "SaveShortlink", query, id, url)
@@ -91,45 +249,194 @@ func (p *Postgres) GetShortlink(ctx context.Context, id string) (string, error)
 	return queryRow(ctx, p, "GetShortlink", scanFunc, query, id)
 }
 
-func (p *Postgres) SaveFileMetadata(ctx context.Context, meta files.FileMetadataDTO) error {
-	query := `INSERT INTO files (id, file_id, name) VALUES ($1, $2, $3) RETURNING id;`
-	return exec(ctx, p, "SaveFileMetadata", query, meta.Id, meta.FileId, meta.Name)
+// Saves file only when its asset is created, asset is locked for share, so it can't be
+// deleted meanwhile. Returns false, when asset is already deleted
+func (p *Postgres) SaveFileMetadata(ctx context.Context, meta files.FileMetadataDTO) (bool, error) {
+	scanFunc := func(row pgx.Row) (bool, error) {
+		saved := ""
+		err := row.Scan(&saved)
+		return err == nil, err
+	}
+
+	query := `INSERT INTO files (id, file_id, name, expires_at, max_downloads, encrypted, mime_type)
+		SELECT $1, $2, $3, $4, nullif($5, 0), $6, $7
+		WHERE EXISTS (SELECT 1 FROM assets WHERE id = $2 AND status = 'created' FOR SHARE)
+		RETURNING id;`
+	return queryRow(ctx, p, "SaveFileMetadata", scanFunc, query,
+		meta.Id, meta.FileId, meta.Name, meta.ExpiresAt, meta.MaxDownloads, meta.Encrypted, meta.MimeType)
+}
+
+// Saves bundle together with moving already saved files into it, so bundle is never
+// seen without its files
+func (p *Postgres) SaveBundleMetadata(ctx context.Context, meta files.BundleMetadataDTO, fileIds []string) error {
+	return transaction(ctx, p, "SaveBundleMetadata", func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `INSERT INTO bundles (id, expires_at) VALUES ($1, $2);`, meta.Id, meta.ExpiresAt); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE files SET bundle_id = $1, updated_at = now() WHERE id = any($2::text[]);`, meta.Id, fileIds)
+		return err
+	})
+}
+
+func (p *Postgres) GetBundleMetadata(ctx context.Context, id string) (*files.BundleMetadataDTO, error) {
+	scanFunc := func(row pgx.Row) (*files.BundleMetadataDTO, error) {
+		dto := &files.BundleMetadataDTO{Id: id}
+		return dto, row.Scan(&dto.ExpiresAt)
+	}
+
+	query := `SELECT expires_at FROM bundles WHERE id = $1;`
+	return queryRow(ctx, p, "GetBundleMetadata", scanFunc, query, id)
+}
+
+func (p *Postgres) GetBundleFiles(ctx context.Context, bundleId string) ([]files.FileMetadataExDTO, error) {
+	scanFunc := func(row pgx.Row) (files.FileMetadataExDTO, error) {
+		dto := files.FileMetadataExDTO{}
+		err := row.Scan(&dto.Id, &dto.FileId, &dto.Name, &dto.Size, &dto.Hash, &dto.ExpiresAt, &dto.Encrypted, &dto.MimeType)
+		return dto, err
+	}
+
+	query := `SELECT f.id, f.file_id, f.name, a.size, a.hash, f.expires_at, f.encrypted, f.mime_type
+		FROM files f
+		JOIN assets a on a.id = f.file_id
+		WHERE f.bundle_id = $1 AND a.status <> 'quarantined'
+		ORDER BY f.created_at, f.id;`
+	return queryRows(ctx, p, "GetBundleFiles", scanFunc, query, bundleId)
 }
 
 func (p *Postgres) GetFileMetadata(ctx context.Context, id string) (*files.FileMetadataExDTO, error) {
 	scanFunc := func(row pgx.Row) (*files.FileMetadataExDTO, error) {
 		dto := &files.FileMetadataExDTO{Id: id}
-		return dto, row.Scan(&dto.FileId, &dto.Name, &dto.Size, &dto.Hash)
+		return dto, row.Scan(&dto.FileId, &dto.Name, &dto.Size, &dto.Hash, &dto.ExpiresAt, &dto.MaxDownloads, &dto.Downloads, &dto.Encrypted, &dto.MimeType,
+			&dto.PreviewId, &dto.PreviewFailed)
 	}
 
-	query := `SELECT f.file_id, f.name, a.size, a.hash 
+	query := `SELECT f.file_id, f.name, a.size, a.hash, f.expires_at, coalesce(f.max_downloads, 0), f.downloads, f.encrypted, f.mime_type,
+			coalesce(f.preview_id, ''), f.preview_failed
 		FROM files f
 		JOIN assets a on a.id = f.file_id
-		WHERE f.id = $1;`
+		WHERE f.id = $1 AND a.status <> 'quarantined';`
 	return queryRow(ctx, p, "GetFileMetadata", scanFunc, query, id)
 }
 
+// Sets preview of file once, empty preview id marks preview failed.
+// Returns false when preview is already set by another worker
+func (p *Postgres) SetFilePreview(ctx context.Context, id, previewId string) (bool, error) {
+	scanFunc := func(row pgx.Row) (bool, error) {
+		updated := ""
+		err := row.Scan(&updated)
+		return err == nil, err
+	}
+
+	query := `UPDATE files SET preview_id = nullif($2, ''), preview_failed = $2 = '', updated_at = now()
+		WHERE id = $1 AND preview_id IS NULL AND NOT preview_failed
+		RETURNING id;`
+	return queryRow(ctx, p, "SetFilePreview", scanFunc, query, id, previewId)
+}
+
+// Counts download if limit is not reached yet. File is expired right after the last allowed
+// download is streamed, expiration with an hour of grace is kept in case it isn't
+func (p *Postgres) IncFileDownloads(ctx context.Context, id string) (bool, error) {
+	scanFunc := func(row pgx.Row) (bool, error) {
+		downloads := 0
+		err := row.Scan(&downloads)
+		return err == nil, err
+	}
+
+	query := `UPDATE files
+		SET downloads = downloads + 1,
+			expires_at = CASE WHEN downloads + 1 >= max_downloads
+				THEN least(coalesce(expires_at, 'infinity'), now() AT TIME ZONE 'utc' + interval '1 hour')
+				ELSE expires_at END,
+			updated_at = now()
+		WHERE id = $1 AND max_downloads IS NOT NULL AND downloads < max_downloads
+		RETURNING downloads;`
+	return queryRow(ctx, p, "IncFileDownloads", scanFunc, query, id)
+}
+
+func (p *Postgres) ExpireFile(ctx context.Context, id string) error {
+	query := `UPDATE files SET expires_at = now() AT TIME ZONE 'utc', updated_at = now() WHERE id = $1;`
+	return exec(ctx, p, "ExpireFile", query, id)
+}
+
+// Saves paste only when its assets are created, like image. Returns false, when some asset is already deleted
+func (p *Postgres) SavePasteMetadata(ctx context.Context, meta pastes.PasteMetadataDTO) (bool, error) {
+	scanFunc := func(row pgx.Row) (bool, error) {
+		saved := ""
+		err := row.Scan(&saved)
+		return err == nil, err
+	}
+
+	assetIds := []string{}
+	for _, id := range []string{meta.AssetId, meta.HighlightedId} {
+		if id != "" {
+			assetIds = append(assetIds, id)
+		}
+	}
+
+	query := `INSERT INTO pastes (id, title, language, content, asset_id, highlighted, highlighted_id, size, expires_at, created_at)
+		SELECT $1, $2, $3, nullif($4, ''), nullif($5, ''), nullif($6, ''), nullif($7, ''), $8, $9, $10
+		WHERE (SELECT count(*) FROM (
+			SELECT 1 FROM assets WHERE id = any($11::text[]) AND status = 'created' FOR SHARE
+		) locked) = cardinality($11::text[])
+		RETURNING id;`
+	return queryRow(ctx, p, "SavePasteMetadata", scanFunc, query,
+		meta.Id, meta.Title, meta.Language, meta.Content, meta.AssetId, meta.Highlighted, meta.HighlightedId, meta.Size, meta.ExpiresAt, meta.CreatedAt, assetIds)
+}
+
+func (p *Postgres) GetPasteMetadata(ctx context.Context, id string) (*pastes.PasteMetadataDTO, error) {
+	scanFunc := func(row pgx.Row) (*pastes.PasteMetadataDTO, error) {
+		dto := &pastes.PasteMetadataDTO{Id: id}
+		return dto, row.Scan(&dto.Title, &dto.Language, &dto.Content, &dto.AssetId, &dto.Highlighted, &dto.HighlightedId,
+			&dto.Size, &dto.ExpiresAt, &dto.CreatedAt)
+	}
+
+	query := `SELECT title, language, coalesce(content, ''), coalesce(asset_id, ''), coalesce(highlighted, ''),
+			coalesce(highlighted_id, ''), size, expires_at, created_at
+		FROM pastes WHERE id = $1;`
+	return queryRow(ctx, p, "GetPasteMetadata", scanFunc, query, id)
+}
+
 func (p *Postgres) GetAssetMetadata(ctx context.Context, id string) (*assets.AssetMetadataDTO, error) {
 	scanFunc := func(row pgx.Row) (*assets.AssetMetadataDTO, error) {
 		dto := &assets.AssetMetadataDTO{Id: id}
-		return dto, row.Scan(&dto.ResourceId, &dto.Size, &dto.Hash, &dto.Bucket)
+		return dto, row.Scan(&dto.ResourceId, &dto.Size, &dto.Hash, &dto.Bucket, &dto.CreatedAt, &dto.KeyId, &dto.DataKey)
 	}
 
-	query := `select resource_id, size, hash, bucket from assets where id = $1;`
+	query := `select resource_id, size, hash, bucket, created_at, coalesce(key_id, ''), data_key from assets where id = $1 and status <> 'quarantined';`
 	return queryRow(ctx, p, "GetAssetMetadata", scanFunc, query, id)
 }
 
+func (p *Postgres) GetAssetDuplicate(ctx context.Context, bucket string, size int, hash string) (*assets.AssetMetadataDTO, error) {
+	scanFunc := func(row pgx.Row) (*assets.AssetMetadataDTO, error) {
+		dto := &assets.AssetMetadataDTO{Size: size, Hash: hash, Bucket: bucket}
+		return dto, row.Scan(&dto.Id, &dto.ResourceId, &dto.CreatedAt, &dto.KeyId, &dto.DataKey, &dto.Scanned)
+	}
+
+	query := `select id, resource_id, created_at, coalesce(key_id, ''), data_key, scanned from assets
+		where hash = $1 and size = $2 and bucket = $3 and status = 'created'
+		order by scanned desc, created_at
+		limit 1;`
+	return queryRow(ctx, p, "GetAssetDuplicate", scanFunc, query, hash, size, bucket)
+}
+
 func (p *Postgres) SaveAssetsMetadata(ctx context.Context, metas ...assets.AssetMetadataDTO) error {
 	defer observe(ctx, p, "SaveAssetsMetadata")()
 
 	rows := [][]any{}
 	for _, meta := range metas {
-		rows = append(rows, []any{meta.Id, meta.ResourceId, meta.Size, meta.Hash, meta.Bucket})
+		var keyId, uploadId *string
+		if meta.KeyId != "" {
+			keyId = &meta.KeyId
+		}
+		if meta.UploadId != "" {
+			uploadId = &meta.UploadId
+		}
+		rows = append(rows, []any{meta.Id, meta.ResourceId, meta.Size, meta.Hash, meta.Bucket, meta.CreatedAt, keyId, meta.DataKey, uploadId})
 	}
 
 	copyCount, err := p.db.CopyFrom(ctx,
 		pgx.Identifier{"assets"},
-		[]string{"id", "resource_id", "size", "hash", "bucket"},
+		[]string{"id", "resource_id", "size", "hash", "bucket", "created_at", "key_id", "data_key", "upload_id"},
 		pgx.CopyFromRows(rows),
 	)
 	if err != nil {
@@ -142,7 +449,174 @@ func (p *Postgres) SaveAssetsMetadata(ctx context.Context, metas ...assets.Asset
 	return nil
 }
 
+// Returns not deleted assets, data keys of which are encrypted by other than given master key
+func (p *Postgres) GetAssetsToReencrypt(ctx context.Context, keyId string, after string, limit int) ([]assets.AssetMetadataDTO, error) {
+	scanFunc := func(row pgx.Row) (assets.AssetMetadataDTO, error) {
+		dto := assets.AssetMetadataDTO{}
+		err := row.Scan(&dto.Id, &dto.ResourceId, &dto.Size, &dto.Hash, &dto.Bucket, &dto.CreatedAt, &dto.KeyId, &dto.DataKey)
+		return dto, err
+	}
+
+	query := `select id, resource_id, size, hash, bucket, created_at, key_id, data_key from assets
+		where key_id is not null and key_id <> $1 and status <> 'deleted' and id > $2
+		order by id
+		limit $3;`
+	return queryRows(ctx, p, "GetAssetsToReencrypt", scanFunc, query, keyId, after, limit)
+}
+
+func (p *Postgres) UpdateAssetDataKey(ctx context.Context, id, keyId string, dataKey []byte) error {
+	query := `update assets set key_id = $2, data_key = $3, updated_at = now() where id = $1;`
+	return exec(ctx, p, "UpdateAssetDataKey", query, id, keyId, dataKey)
+}
+
+// Asset references with expiration of referencing record, null expiration means forever.
+// Variant is referenced by each image of its source, so one referencing record may give
+// several rows, records are told apart by ref. Variant stored as its source isn't a reference
+const assetReferencesQuery = `WITH refs AS (
+		SELECT file_id AS asset_id, expires_at, 'file:' || id AS ref FROM files
+		UNION ALL SELECT preview_id, expires_at, 'file:' || id FROM files WHERE preview_id IS NOT NULL
+		UNION ALL SELECT original_id, expires_at, 'image:' || id FROM images
+		UNION ALL SELECT thumbnail_id, expires_at, 'image:' || id FROM images WHERE thumbnail_id IS NOT NULL
+		UNION ALL SELECT watermarked_id, expires_at, 'image:' || id FROM images WHERE watermarked_id IS NOT NULL
+		UNION ALL SELECT v.asset_id, i.expires_at, 'variant:' || v.source_id || ':' || v.format FROM image_variants v
+			JOIN images i ON v.source_id IN (i.original_id, i.thumbnail_id, i.watermarked_id)
+			WHERE v.asset_id <> v.source_id
+		UNION ALL SELECT asset_id, expires_at, 'paste:' || id FROM pastes WHERE asset_id IS NOT NULL
+		UNION ALL SELECT highlighted_id, expires_at, 'paste:' || id FROM pastes WHERE highlighted_id IS NOT NULL
+	)`
+
+// Returns created assets, all references to which are expired, and created assets
+// without references, which were last updated long enough ago
+func (p *Postgres) GetExpiredAssets(ctx context.Context, after string, unreferencedFor time.Duration, limit int) ([]string, error) {
+	scanFunc := func(row pgx.Row) (string, error) {
+		id := ""
+		err := row.Scan(&id)
+		return id, err
+	}
+
+	query := assetReferencesQuery + `
+		SELECT a.id FROM assets a
+		LEFT JOIN refs r ON r.asset_id = a.id
+		WHERE a.status = 'created' AND a.id > $1
+		GROUP BY a.id
+		HAVING count(r.asset_id) > 0
+				AND bool_and(r.expires_at IS NOT NULL AND r.expires_at <= now() AT TIME ZONE 'utc')
+			OR count(r.asset_id) = 0 AND max(a.updated_at) < now() - make_interval(secs => $2)
+		ORDER BY a.id
+		LIMIT $3;`
+	return queryRows(ctx, p, "GetExpiredAssets", scanFunc, query, after, unreferencedFor.Seconds(), limit)
+}
+
+// Marks asset deleted when it has no unexpired references and returns its location,
+// nil means asset is still referenced. Asset is locked before references are counted:
+// record referencing asset locks it for share while it's inserted, so its insertion is
+// either committed before references are counted, or it finds asset deleted and inserts
+// nothing. Deleted asset is returned again, so removing its object can be retried
+func (p *Postgres) ClaimUnreferencedAsset(ctx context.Context, id string) (*assets.AssetMetadataDTO, error) {
+	query := assetReferencesQuery + `
+		UPDATE assets a SET status = 'deleted', updated_at = now()
+		WHERE a.id = $1 AND a.status IN ('created', 'deleted')
+			AND NOT EXISTS (SELECT 1 FROM refs r WHERE r.asset_id = a.id
+				AND (r.expires_at IS NULL OR r.expires_at > now() AT TIME ZONE 'utc'))
+		RETURNING a.resource_id, a.bucket;`
+
+	var claimed *assets.AssetMetadataDTO
+	err := transaction(ctx, p, "ClaimUnreferencedAsset", func(tx pgx.Tx) error {
+		// references are counted by next statement, which sees records committed while waiting for lock
+		if _, err := tx.Exec(ctx, `SELECT 1 FROM assets WHERE id = $1 FOR UPDATE;`, id); err != nil {
+			return err
+		}
+
+		dto := &assets.AssetMetadataDTO{Id: id}
+		err := tx.QueryRow(ctx, query, id).Scan(&dto.ResourceId, &dto.Bucket)
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		claimed = dto
+		return nil
+	})
+	return claimed, err
+}
+
+// Returns created assets referenced by several records, live references are unexpired ones
+func (p *Postgres) GetSharedAssets(ctx context.Context, after string, limit int) ([]assets.SharedAssetDTO, error) {
+	scanFunc := func(row pgx.Row) (assets.SharedAssetDTO, error) {
+		dto := assets.SharedAssetDTO{}
+		err := row.Scan(&dto.Id, &dto.Bucket, &dto.Size, &dto.CreatedAt, &dto.References, &dto.LiveReferences)
+		return dto, err
+	}
+
+	query := assetReferencesQuery + `
+		SELECT a.id, a.bucket, a.size, a.created_at, count(DISTINCT r.ref),
+			count(DISTINCT r.ref) FILTER (WHERE r.expires_at IS NULL OR r.expires_at > now() AT TIME ZONE 'utc')
+		FROM assets a
+		JOIN refs r ON r.asset_id = a.id
+		WHERE a.status = 'created' AND a.id > $1
+		GROUP BY a.id
+		HAVING count(DISTINCT r.ref) > 1
+		ORDER BY a.id
+		LIMIT $2;`
+	return queryRows(ctx, p, "GetSharedAssets", scanFunc, query, after, limit)
+}
+
 func (p *Postgres) SetAssetsStatus(ctx context.Context, status assets.AssetStatus, ids ...string) error {
-	query := `update assets set status=$1 where id in ($2);`
-	return exec(ctx, p, "SetAssetsStatus", query, status, strings.Join(ids, ","))
+	query := `update assets set status=$1 where id = any($2);`
+	return exec(ctx, p, "SetAssetsStatus", query, status, ids)
+}
+
+func (p *Postgres) SetAssetScanned(ctx context.Context, id string) error {
+	query := `update assets set scanned=true where id = $1;`
+	return exec(ctx, p, "SetAssetScanned", query, id)
+}
+
+// Changes status only of assets with expected current status, returns ids of changed assets
+func (p *Postgres) ChangeAssetsStatus(ctx context.Context, from, to assets.AssetStatus, ids ...string) ([]string, error) {
+	scanFunc := func(row pgx.Row) (string, error) {
+		id := ""
+		err := row.Scan(&id)
+		return id, err
+	}
+
+	query := `update assets set status=$2 where id = any($3) and status=$1 returning id;`
+	return queryRows(ctx, p, "ChangeAssetsStatus", scanFunc, query, from, to, ids)
+}
+
+// Returns pending assets created before given age, assets of multipart uploads are
+// returned only after their own age. Referenced flag marks assets, which are already
+// used by records, though their saving wasn't completed
+func (p *Postgres) GetPendingAssets(ctx context.Context, after string, olderThan, uploadsOlderThan time.Duration, limit int) ([]assets.PendingAssetDTO, error) {
+	scanFunc := func(row pgx.Row) (assets.PendingAssetDTO, error) {
+		dto := assets.PendingAssetDTO{}
+		err := row.Scan(&dto.Id, &dto.ResourceId, &dto.Bucket, &dto.CreatedAt, &dto.UploadId, &dto.Referenced)
+		return dto, err
+	}
+
+	query := assetReferencesQuery + `
+		SELECT a.id, a.resource_id, a.bucket, a.created_at, coalesce(a.upload_id, ''),
+			EXISTS (SELECT 1 FROM refs r WHERE r.asset_id = a.id)
+		FROM assets a
+		WHERE a.status = 'pending' AND a.id > $1
+			AND a.created_at < now() AT TIME ZONE 'utc' -
+				make_interval(secs => CASE WHEN a.upload_id IS NULL THEN $2 ELSE $3 END)
+		ORDER BY a.id
+		LIMIT $4;`
+	return queryRows(ctx, p, "GetPendingAssets", scanFunc, query, after, olderThan.Seconds(), uploadsOlderThan.Seconds(), limit)
+}
+
+// Fills content of pending asset known after its object is written and changes its status.
+// False is returned, when asset isn't pending anymore
+func (p *Postgres) CompletePendingAsset(ctx context.Context, meta assets.AssetMetadataDTO, status assets.AssetStatus) (bool, error) {
+	scanFunc := func(row pgx.Row) (bool, error) {
+		id := ""
+		err := row.Scan(&id)
+		return id != "", err
+	}
+
+	query := `update assets set status = $2, size = $3, hash = $4, created_at = $5, updated_at = now()
+		where id = $1 and status = 'pending'
+		returning id;`
+	return queryRow(ctx, p, "CompletePendingAsset", scanFunc, query, meta.Id, status, meta.Size, meta.Hash, meta.CreatedAt)
 }
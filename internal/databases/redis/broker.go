@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"shorty/internal/common/broker"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	brokerGroup = "shorty"
+
+	imagesToProcessStream = "broker:images_process"
+	filesToDeleteStream   = "broker:files_delete"
+	previewsCreateStream  = "broker:previews_create"
+
+	// Messages not acked during this time are considered lost and claimed by another consumer
+	brokerClaimIdle = 5 * time.Minute
+)
+
+func (r *redisDb) createBrokerGroups(ctx context.Context) error {
+	for _, stream := range []string{imagesToProcessStream, filesToDeleteStream, previewsCreateStream} {
+		err := r.rdb.XGroupCreateMkStream(ctx, stream, brokerGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *redisDb) putMessages(ctx context.Context, stream string, values ...string) error {
+	pipe := r.rdb.Pipeline()
+	for _, value := range values {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			Values: map[string]any{"value": value},
+		})
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisDb) getMessages(ctx context.Context, stream, consumer string, count int, block time.Duration) ([]broker.Message, error) {
+	claimed, _, err := r.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    brokerGroup,
+		Consumer: consumer,
+		MinIdle:  brokerClaimIdle,
+		Start:    "0",
+		Count:    int64(count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(claimed) > 0 {
+		return toBrokerMessages(claimed), nil
+	}
+
+	streams, err := r.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    brokerGroup,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    int64(count),
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []broker.Message{}
+	for _, s := range streams {
+		messages = append(messages, toBrokerMessages(s.Messages)...)
+	}
+	return messages, nil
+}
+
+func (r *redisDb) ackMessages(ctx context.Context, stream string, messageIds ...string) error {
+	return r.rdb.XAck(ctx, stream, brokerGroup, messageIds...).Err()
+}
+
+func toBrokerMessages(xMessages []redis.XMessage) []broker.Message {
+	messages := make([]broker.Message, len(xMessages))
+	for i, msg := range xMessages {
+		value, _ := msg.Values["value"].(string)
+		messages[i] = broker.Message{Id: msg.ID, Value: value}
+	}
+	return messages
+}
+
+func (r *redisDb) PutFilesToDelete(ctx context.Context, names ...string) error {
+	defer r.observe(ctx, "PutFilesToDelete")()
+	return r.putMessages(ctx, filesToDeleteStream, names...)
+}
+
+func (r *redisDb) GetFilesToDelete(ctx context.Context, consumer string, count int, block time.Duration) ([]broker.Message, error) {
+	// not observed, blocks until messages arrive
+	return r.getMessages(ctx, filesToDeleteStream, consumer, count, block)
+}
+
+func (r *redisDb) AckFilesToDelete(ctx context.Context, messageIds ...string) error {
+	defer r.observe(ctx, "AckFilesToDelete")()
+	return r.ackMessages(ctx, filesToDeleteStream, messageIds...)
+}
+
+func (r *redisDb) PutImagesToProcess(ctx context.Context, ids ...string) error {
+	defer r.observe(ctx, "PutImagesToProcess")()
+	return r.putMessages(ctx, imagesToProcessStream, ids...)
+}
+
+func (r *redisDb) GetImagesToProcess(ctx context.Context, consumer string, count int, block time.Duration) ([]broker.Message, error) {
+	// not observed, blocks until messages arrive
+	return r.getMessages(ctx, imagesToProcessStream, consumer, count, block)
+}
+
+func (r *redisDb) AckImagesToProcess(ctx context.Context, messageIds ...string) error {
+	defer r.observe(ctx, "AckImagesToProcess")()
+	return r.ackMessages(ctx, imagesToProcessStream, messageIds...)
+}
+
+func (r *redisDb) PutPreviewsToCreate(ctx context.Context, ids ...string) error {
+	defer r.observe(ctx, "PutPreviewsToCreate")()
+	return r.putMessages(ctx, previewsCreateStream, ids...)
+}
+
+func (r *redisDb) GetPreviewsToCreate(ctx context.Context, consumer string, count int, block time.Duration) ([]broker.Message, error) {
+	// not observed, blocks until messages arrive
+	return r.getMessages(ctx, previewsCreateStream, consumer, count, block)
+}
+
+func (r *redisDb) AckPreviewsToCreate(ctx context.Context, messageIds ...string) error {
+	defer r.observe(ctx, "AckPreviewsToCreate")()
+	return r.ackMessages(ctx, previewsCreateStream, messageIds...)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"shorty/internal/common/metrics"
 	"shorty/internal/services/assets"
+	"shorty/internal/services/uploads"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -23,7 +24,7 @@ func New(ctx context.Context, redisUrl string, tracer trace.Tracer, meter metric
 		return nil, fmt.Errorf("error pinging redis: %w", err)
 	}
 
-	return &redisDb{
+	r := &redisDb{
 		rdb:    rdb,
 		tracer: tracer,
 		latencyHist: meter.NewHistogram(
@@ -32,7 +33,13 @@ func New(ctx context.Context, redisUrl string, tracer trace.Tracer, meter metric
 			[]float64{1, 10, 50, 100, 200},
 		),
 		// errorsCounter: meter.NewCounter("redis_query_errors", "Count of Redis query errors"),
-	}, nil
+	}
+
+	if err := r.createBrokerGroups(ctx); err != nil {
+		return nil, fmt.Errorf("error creating broker groups: %w", err)
+	}
+
+	return r, nil
 }
 
 type redisDb struct {
@@ -141,3 +148,63 @@ func (r *redisDb) GetAssetMetadata(ctx context.Context, id string) (*assets.Asse
 
 	return meta, nil
 }
+
+func (r *redisDb) DeleteAssetMetadata(ctx context.Context, id string) error {
+	defer r.observe(ctx, "DeleteAssetMetadata")()
+
+	key := fmt.Sprintf("asset:%s", id)
+	return r.rdb.Del(ctx, key).Err()
+}
+
+func (r *redisDb) SaveUpload(ctx context.Context, upload uploads.UploadDTO, ttl time.Duration) error {
+	defer r.observe(ctx, "SaveUpload")()
+
+	bytes, err := msgpack.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("upload:%s", upload.Id)
+	return r.rdb.SetEx(ctx, key, bytes, ttl).Err()
+}
+
+func (r *redisDb) GetUpload(ctx context.Context, id string) (*uploads.UploadDTO, error) {
+	defer r.observe(ctx, "GetUpload")()
+
+	key := fmt.Sprintf("upload:%s", id)
+	bytes, err := r.rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &uploads.UploadDTO{}
+	if err := msgpack.Unmarshal(bytes, upload); err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+func (r *redisDb) DeleteUpload(ctx context.Context, id string) error {
+	defer r.observe(ctx, "DeleteUpload")()
+
+	key := fmt.Sprintf("upload:%s", id)
+	return r.rdb.Del(ctx, key).Err()
+}
+
+func (r *redisDb) LockUpload(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	defer r.observe(ctx, "LockUpload")()
+
+	key := fmt.Sprintf("upload_lock:%s", id)
+	return r.rdb.SetNX(ctx, key, true, ttl).Result()
+}
+
+func (r *redisDb) UnlockUpload(ctx context.Context, id string) error {
+	defer r.observe(ctx, "UnlockUpload")()
+
+	key := fmt.Sprintf("upload_lock:%s", id)
+	return r.rdb.Del(ctx, key).Err()
+}
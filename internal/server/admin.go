@@ -0,0 +1,86 @@
+package server
+
+import (
+	"shorty/internal/services/image"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *server) ThumbnailsRegenerateStart(c *gin.Context) {
+	err := s.ImageService.StartThumbnailsRegeneration(c, s.ctx)
+	if err == image.ErrJobRunning {
+		c.JSON(409, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	s.ThumbnailsRegenerateProgress(c)
+}
+
+func (s *server) ThumbnailsRegenerateProgress(c *gin.Context) {
+	progress := s.ImageService.ThumbnailsRegenerationProgress()
+
+	resp := gin.H{
+		"status":    "ok",
+		"running":   progress.Running,
+		"total":     progress.Total,
+		"processed": progress.Processed,
+		"failed":    progress.Failed,
+	}
+	if !progress.StartedAt.IsZero() {
+		resp["startedAt"] = progress.StartedAt.Format(time.RFC3339)
+	}
+	if !progress.FinishedAt.IsZero() {
+		resp["finishedAt"] = progress.FinishedAt.Format(time.RFC3339)
+	}
+
+	c.JSON(200, resp)
+}
+
+const (
+	sharedAssetsPageSize    = 100
+	sharedAssetsMaxPageSize = 1000
+)
+
+// Reports assets referenced by several images or files, page is continued from "next" id
+func (s *server) SharedAssets(c *gin.Context) {
+	limit := sharedAssetsPageSize
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > sharedAssetsMaxPageSize {
+			c.JSON(400, gin.H{"status": "error", "message": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	shared, err := s.AssetStorage.GetSharedAssets(c, c.Query("after"), limit)
+	if err != nil {
+		c.JSON(500, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+
+	items, savedBytes := make([]gin.H, len(shared)), 0
+	for i, asset := range shared {
+		items[i] = gin.H{
+			"id":             asset.Id,
+			"bucket":         asset.Bucket,
+			"size":           asset.Size,
+			"createdAt":      asset.CreatedAt.Format(time.RFC3339),
+			"references":     asset.References,
+			"liveReferences": asset.LiveReferences,
+		}
+		savedBytes += asset.Size * (asset.References - 1)
+	}
+
+	resp := gin.H{"status": "ok", "assets": items, "savedBytes": savedBytes}
+	if len(shared) == limit {
+		resp["next"] = shared[len(shared)-1].Id
+	}
+	c.JSON(200, resp)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"shorty/internal/server/pages"
+	"shorty/internal/services/files"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *server) BundleDownload(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		s.pages.NotFound(c)
+		return
+	}
+
+	captchaId, captchaToken := c.Query("id"), c.Query("token")
+	err := s.GuardService.CheckCaptcha(c, captchaId, captchaToken)
+	if err != nil {
+		c.Redirect(302, fmt.Sprintf("/bundle/view/%s?err=%s", id, url.QueryEscape("captcha wrong or expired")))
+		return
+	}
+
+	bundle, err := s.FileService.GetBundle(c, id)
+	if err == files.ErrNotFound {
+		s.pages.NotFound(c)
+		return
+	}
+	if err != nil {
+		s.pages.InternalError(c)
+		return
+	}
+
+	token := NewResourceToken(id, time.Now().Add(15*time.Minute))
+	archiveUrl := fmt.Sprintf("%s/b/%s/%s?token=%s&expires=%d", s.Url, bundle.Id, bundleArchiveName(bundle), token.Value, token.Exipres)
+
+	s.pages.FileDownload(c, pages.FileDownloadParams{FileRawUrl: archiveUrl})
+}
+
+func bundleArchiveName(bundle *files.BundleDTO) string {
+	return fmt.Sprintf("bundle-%s.zip", bundle.Id[:8])
+}
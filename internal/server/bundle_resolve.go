@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"shorty/internal/common"
+	"shorty/internal/services/files"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Streams bundle as ZIP archive built on the fly, archive size is unknown
+// beforehand, so it is sent chunked without range requests support
+func (s *server) BundleResolve(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		s.pages.NotFound(c)
+		return
+	}
+
+	token, expiresStr := c.Query("token"), c.Query("expires")
+	expires, _ := strconv.Atoi(expiresStr)
+
+	expired := int(time.Now().Unix()) > expires
+	valid := CheckResourceToken(id, int64(expires), token)
+
+	if expired || !valid {
+		s.Logger.WithContext(c).Info().Msgf("bundle (id=%s) token(%s) expired, redirecting to view", id, common.MaskSecret(token))
+		viewUrl := fmt.Sprintf("%s/bundle/view/%s?err=%s", s.Url, id, url.QueryEscape("Download link expired"))
+		c.Redirect(302, viewUrl)
+		return
+	}
+
+	bundle, err := s.FileService.GetBundle(c, id)
+	if err == files.ErrNotFound {
+		s.pages.NotFound(c)
+		return
+	}
+	if err != nil {
+		s.pages.InternalError(c)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", contentDisposition("attachment", bundleArchiveName(bundle)))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	// response is already started, so error can only be logged and archive is left truncated
+	if err := s.FileService.WriteBundleArchive(c, bundle, c.Writer); err != nil {
+		s.Logger.WithContext(c).Error().Err(err).Msgf("failed streaming bundle (id=%s) archive", id)
+		c.Abort()
+	}
+}
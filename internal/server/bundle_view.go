@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"shorty/internal/server/pages"
+	"shorty/internal/services/files"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *server) BundleView(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		s.pages.NotFound(c)
+		return
+	}
+
+	bundle, err := s.FileService.GetBundle(c, id)
+	if err == files.ErrNotFound {
+		s.pages.NotFound(c)
+		return
+	}
+	if err != nil {
+		s.pages.InternalError(c)
+		return
+	}
+
+	captcha, _ := s.GuardService.CreateCaptcha(c)
+
+	bundleFiles := make([]pages.BundleFileParams, len(bundle.Files))
+	for i, file := range bundle.Files {
+		bundleFiles[i] = pages.BundleFileParams{
+			FileName:    file.Name,
+			FileSizeMB:  float32(file.PlainSize()) / (1024 * 1024),
+			FileViewUrl: fmt.Sprintf("%s/file/view/%s", s.Url, file.Id),
+		}
+	}
+
+	s.pages.BundleView(c, pages.BundleViewParams{
+		Files:             bundleFiles,
+		SizeMB:            float32(bundle.Size()) / (1024 * 1024),
+		BundleViewUrl:     fmt.Sprintf("%s/bundle/view/%s", s.Url, bundle.Id),
+		BundleDownloadUrl: fmt.Sprintf("%s/bundle/download/%s", s.Url, bundle.Id),
+		CaptchaId:         captcha.Id,
+		CaptchaBase64:     captcha.ImageBase64,
+		ExpiresAt:         formatExpiresAt(bundle.ExpiresAt),
+	})
+}
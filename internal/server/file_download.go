@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net/url"
+	"shorty/internal/server/pages"
 	"shorty/internal/services/files"
 	"time"
 
@@ -36,5 +37,9 @@ func (s *server) FileDownload(c *gin.Context) {
 	token := NewResourceToken(id, time.Now().Add(15*time.Minute))
 	fileRawUrl := fmt.Sprintf("%s/f/%s/%s?token=%s&expires=%d", s.Url, meta.Id, meta.Name, token.Value, token.Exipres)
 
-	s.pages.FileDownload(c, fileRawUrl)
+	s.pages.FileDownload(c, pages.FileDownloadParams{
+		FileRawUrl: fileRawUrl,
+		FileName:   meta.Name,
+		Encrypted:  meta.Encrypted,
+	})
 }
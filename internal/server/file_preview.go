@@ -0,0 +1,45 @@
+package server
+
+import (
+	"shorty/internal/services/files"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Serves stored thumbnail of image file, it is created once by preview worker
+func (s *server) FilePreview(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		s.pages.NotFound(c)
+		return
+	}
+
+	meta, err := s.FileService.GetFileMetadata(c, id)
+	if err == files.ErrNotFound {
+		s.pages.NotFound(c)
+		return
+	}
+	if err != nil {
+		s.pages.InternalError(c)
+		return
+	}
+
+	if meta.PreviewId != "" && s.serveNotModified(c, meta.PreviewId) {
+		c.Header("Cache-Control", "private, max-age=86400")
+		return
+	}
+
+	asset, err := s.FileService.GetPreviewAsset(c, meta)
+	if err == files.ErrNotFound {
+		s.pages.NotFound(c)
+		return
+	}
+	if err != nil {
+		s.pages.InternalError(c)
+		return
+	}
+	defer asset.Body.Close()
+
+	c.Header("Cache-Control", "private, max-age=86400")
+	serveAsset(c, "image/jpeg", asset)
+}
@@ -31,7 +31,7 @@ func (s *server) FileResolve(c *gin.Context) {
 		return
 	}
 
-	fileBytes, err := s.FileService.GetFileBytes(c, id)
+	meta, err := s.FileService.GetFileMetadata(c, id)
 	if err == files.ErrNotFound {
 		s.pages.NotFound(c)
 		return
@@ -41,5 +41,32 @@ func (s *server) FileResolve(c *gin.Context) {
 		return
 	}
 
-	c.Data(200, "application/octet-stream", fileBytes)
+	if meta.Limited() {
+		// every request is counted as download, so partial and conditional requests are not supported
+		for _, header := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+			c.Request.Header.Del(header)
+		}
+	} else if s.serveNotModified(c, meta.FileId) {
+		return
+	}
+
+	asset, err := s.FileService.GetFile(c, meta)
+	if err == files.ErrNotFound {
+		s.pages.NotFound(c)
+		return
+	}
+	if err != nil {
+		s.pages.InternalError(c)
+		return
+	}
+
+	defer asset.Body.Close()
+
+	if meta.Limited() {
+		c.Header("Cache-Control", "no-store")
+	}
+
+	contentType, disposition := safeContentType(meta.MimeType)
+	c.Header("Content-Disposition", contentDisposition(disposition, meta.Name))
+	serveAsset(c, contentType, asset)
 }
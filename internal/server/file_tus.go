@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"shorty/internal/services/files"
+	"shorty/internal/services/uploads"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resumable file uploads, implements core tus 1.0.0 protocol with
+// creation, expiration and termination extensions
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+func tusMiddleware(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	c.Next()
+}
+
+// Parses Upload-Metadata header, values are base64 encoded
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for key %s", key)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+func (s *server) tusUploadUrl(id string) string {
+	return fmt.Sprintf("%s/api/uploads/%s", s.Url, id)
+}
+
+func tusUploadHeaders(c *gin.Context, upload *uploads.UploadDTO) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset(), 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Finished() {
+		c.Header("Shorty-File-Url", fmt.Sprintf("/file/view/%s", upload.FileId))
+	}
+}
+
+func (s *server) tusError(c *gin.Context, err error) {
+	switch err {
+	case uploads.ErrOptions:
+		c.AbortWithStatus(http.StatusBadRequest)
+	case uploads.ErrNotFound:
+		c.AbortWithStatus(http.StatusNotFound)
+	case uploads.ErrTooBig:
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+	case uploads.ErrOffsetMismatch:
+		c.AbortWithStatus(http.StatusConflict)
+	case uploads.ErrLocked:
+		c.AbortWithStatus(http.StatusLocked)
+	case uploads.ErrFinished:
+		c.AbortWithStatus(http.StatusForbidden)
+	case files.ErrInfected:
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+	case files.ErrScan:
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	default:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+func (s *server) TusOptions(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(s.UploadService.MaxSize(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// Creates upload, captcha is passed in metadata since upload has no form
+func (s *server) TusCreate(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := s.GuardService.CheckCaptcha(c, metadata["captcha_id"], metadata["captcha_token"]); err != nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	opts, err := parseFileOptions(metadata["retention"], metadata["max_downloads"], metadata["encrypted"])
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	name := metadata["filename"]
+	if name == "" {
+		name = fmt.Sprintf("upload-%d", time.Now().Unix())
+	}
+
+	upload, err := s.UploadService.CreateUpload(c, name, length, opts)
+	if err != nil {
+		s.tusError(c, err)
+		return
+	}
+
+	tusUploadHeaders(c, upload)
+	c.Header("Location", s.tusUploadUrl(upload.Id))
+	c.Status(http.StatusCreated)
+}
+
+func (s *server) TusHead(c *gin.Context) {
+	upload, err := s.UploadService.GetUpload(c, c.Param("id"))
+	if err != nil {
+		s.tusError(c, err)
+		return
+	}
+
+	tusUploadHeaders(c, upload)
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+func (s *server) TusPatch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	upload, err := s.UploadService.WriteChunk(c, c.Param("id"), offset, c.Request.Body)
+	if err != nil {
+		s.tusError(c, err)
+		return
+	}
+
+	tusUploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+func (s *server) TusDelete(c *gin.Context) {
+	if err := s.UploadService.TerminateUpload(c, c.Param("id")); err != nil {
+		s.tusError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
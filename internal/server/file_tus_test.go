@@ -0,0 +1,25 @@
+package server
+
+import "testing"
+
+func TestParseTusMetadata(t *testing.T) {
+	metadata, err := parseTusMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==, is_confidential,captcha_id YWJj")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"filename":        "world_domination_plan.pdf",
+		"is_confidential": "",
+		"captcha_id":      "abc",
+	}
+	for key, value := range expected {
+		if metadata[key] != value {
+			t.Fatalf("key %s: expected %q, got %q", key, value, metadata[key])
+		}
+	}
+
+	if _, err := parseTusMetadata("filename !!!"); err == nil {
+		t.Fatalf("expected error for invalid base64 value")
+	}
+}
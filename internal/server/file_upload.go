@@ -3,7 +3,11 @@ package server
 import (
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/url"
+	"shorty/internal/common"
+	"shorty/internal/services/files"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
@@ -17,12 +21,42 @@ func (s *server) FileUpload(c *gin.Context) {
 		return
 	}
 
-	header, err := c.FormFile("file")
+	opts, err := parseFileOptions(c.PostForm("retention"), c.PostForm("max_downloads"), c.PostForm("encrypted"))
+	if err != nil {
+		c.Redirect(302, "/file?err="+url.QueryEscape(err.Error()))
+		return
+	}
+
+	if rawUrl := c.PostForm("url"); rawUrl != "" {
+		if opts.Encrypted {
+			c.Redirect(302, "/file?err="+url.QueryEscape("remote file can't be encrypted in browser"))
+			return
+		}
+		remote, ok := s.fetchRemote(c, "/file", rawUrl, files.MaxSize)
+		if !ok {
+			return
+		}
+		defer remote.Close()
+
+		s.fileUpload(c, remote.Name, remote, remote.Size, opts)
+		return
+	}
+
+	form, err := c.MultipartForm()
 	if err != nil {
 		log.Error().Err(err).Msg("error getting file from request")
 		s.pages.InternalError(c)
 		return
 	}
+	if len(form.File["file"]) == 0 {
+		c.Redirect(302, "/file?err="+url.QueryEscape("no file selected"))
+		return
+	}
+	if len(form.File["file"]) > 1 {
+		s.bundleUpload(c, form.File["file"], opts)
+		return
+	}
+	header := form.File["file"][0]
 
 	file, err := header.Open()
 	if err != nil {
@@ -32,9 +66,15 @@ func (s *server) FileUpload(c *gin.Context) {
 	}
 	defer file.Close()
 
-	bytes, _ := io.ReadAll(file)
+	s.fileUpload(c, header.Filename, file, header.Size, opts)
+}
 
-	meta, err := s.FileService.UploadFile(c, header.Filename, bytes)
+func (s *server) fileUpload(c *gin.Context, name string, file io.ReadSeeker, size int64, opts files.FileOptions) {
+	meta, err := s.FileService.UploadFile(c, name, file, size, opts)
+	if err == files.ErrTooBig || err == files.ErrOptions || err == files.ErrInfected || err == files.ErrScan {
+		c.Redirect(302, "/file?err="+url.QueryEscape(err.Error()))
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("error uploading file")
 		s.pages.InternalError(c)
@@ -44,3 +84,53 @@ func (s *server) FileUpload(c *gin.Context) {
 	viewUrl := fmt.Sprintf("/file/view/%s", meta.Id)
 	c.Redirect(302, viewUrl)
 }
+
+// Several files of one form are uploaded as bundle
+func (s *server) bundleUpload(c *gin.Context, headers []*multipart.FileHeader, opts files.FileOptions) {
+	bundleFiles := make([]files.BundleFile, 0, len(headers))
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			log.Error().Err(err).Msg("error opening tmp file")
+			s.pages.InternalError(c)
+			return
+		}
+		defer file.Close()
+
+		bundleFiles = append(bundleFiles, files.BundleFile{Name: header.Filename, Body: file, Size: header.Size})
+	}
+
+	bundle, err := s.FileService.UploadBundle(c, bundleFiles, opts)
+	if err == files.ErrTooBig || err == files.ErrOptions || err == files.ErrBundleFiles ||
+		err == files.ErrInfected || err == files.ErrScan {
+		c.Redirect(302, "/file?err="+url.QueryEscape(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error uploading bundle")
+		s.pages.InternalError(c)
+		return
+	}
+
+	c.Redirect(302, fmt.Sprintf("/bundle/view/%s", bundle.Id))
+}
+
+// Parses file options from upload form values, empty values mean defaults
+func parseFileOptions(retention, maxDownloads, encrypted string) (files.FileOptions, error) {
+	opts := files.FileOptions{Encrypted: encrypted == "true"}
+
+	var err error
+	opts.Retention, err = common.ParseRetention(retention)
+	if err != nil {
+		return opts, fmt.Errorf("unknown retention")
+	}
+
+	if maxDownloads != "" {
+		opts.MaxDownloads, err = strconv.Atoi(maxDownloads)
+		if err != nil {
+			return opts, fmt.Errorf("invalid max downloads")
+		}
+	}
+
+	return opts, opts.Validate()
+}
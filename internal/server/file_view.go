@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"html/template"
 	"shorty/internal/server/pages"
 	"shorty/internal/services/files"
 
@@ -32,10 +33,43 @@ func (s *server) FileView(c *gin.Context) {
 
 	s.pages.FileView(c, pages.FileViewParams{
 		FileName:        meta.Name,
-		FileSizeMB:      float32(meta.Size) / (1024 * 1024),
+		FileSizeMB:      float32(meta.PlainSize()) / (1024 * 1024),
 		FileViewUrl:     viewUrl,
 		FileDownloadUrl: downloadUrl,
 		CaptchaId:       captcha.Id,
 		CaptchaBase64:   captcha.ImageBase64,
+		ExpiresAt:       formatExpiresAt(meta.ExpiresAt),
+		Limited:         meta.Limited(),
+		DownloadsLeft:   meta.RemainingDownloads(),
+		Encrypted:       meta.Encrypted,
+		Checksum:        meta.Hash,
+		Preview:         s.filePreview(c, meta),
 	})
 }
+
+// Preview failure doesn't break view page, file is just shown without preview
+func (s *server) filePreview(c *gin.Context, meta *files.FileMetadataExDTO) pages.FilePreviewParams {
+	preview, err := s.FileService.GetFilePreview(c, meta)
+	if err != nil {
+		return pages.FilePreviewParams{}
+	}
+
+	params := pages.FilePreviewParams{Kind: string(preview.Kind), Truncated: preview.Truncated}
+	switch preview.Kind {
+	case files.PreviewText:
+		params.Text = template.HTML(preview.Highlighted) // escaped by highlighter
+	case files.PreviewImage:
+		params.ImageUrl = fmt.Sprintf("/file/preview/%s", meta.Id)
+	case files.PreviewPdf:
+		params.PdfVersion, params.PdfPages = preview.Pdf.Version, preview.Pdf.Pages
+	case files.PreviewArchive:
+		for _, entry := range preview.Entries {
+			params.ArchiveEntries = append(params.ArchiveEntries, pages.ArchiveEntryParams{
+				Name:   entry.Name,
+				SizeKB: float32(entry.Size) / 1024,
+				Dir:    entry.Dir,
+			})
+		}
+	}
+	return params
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	goimage "image"
+	"net/http"
+	"shorty/internal/services/image"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type imageEditRequest struct {
+	Crop *struct {
+		X      int `json:"x"`
+		Y      int `json:"y"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"crop"`
+	Rotate int  `json:"rotate"`
+	FlipH  bool `json:"flipH"`
+	FlipV  bool `json:"flipV"`
+
+	CaptchaId    string `json:"captchaId"`
+	CaptchaToken string `json:"captchaToken"`
+
+	// raw image token, given to uploader, allows editing original without watermark
+	Token   string `json:"token"`
+	Expires int64  `json:"expires"`
+}
+
+// Editing is gated like uploads: by captcha, or by raw image token of the source
+func (s *server) ImageEdit(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(404, gin.H{"status": "error", "message": "not found"})
+		return
+	}
+
+	req := imageEditRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"status": "error", "message": "bad request body"})
+		return
+	}
+
+	raw := req.Token != ""
+	if raw {
+		if time.Now().Unix() > req.Expires || !CheckResourceToken(rawImageResource(id), req.Expires, req.Token) {
+			c.JSON(403, gin.H{"status": "error", "message": "token wrong or expired"})
+			return
+		}
+	} else if err := s.GuardService.CheckCaptcha(c, req.CaptchaId, req.CaptchaToken); err != nil {
+		c.JSON(403, gin.H{"status": "error", "message": "captcha wrong or expired"})
+		return
+	}
+
+	opts := image.EditOptions{
+		Rotate: req.Rotate,
+		FlipH:  req.FlipH,
+		FlipV:  req.FlipV,
+		Raw:    raw,
+	}
+	if req.Crop != nil {
+		crop := goimage.Rect(req.Crop.X, req.Crop.Y, req.Crop.X+req.Crop.Width, req.Crop.Y+req.Crop.Height)
+		opts.Crop = &crop
+	}
+
+	meta, err := s.ImageService.EditImage(c, id, opts)
+	if err == image.ErrImageNotFound {
+		c.JSON(404, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+	if err == image.ErrImageProcessing {
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+	if err == image.ErrInvalidEdit || err == image.ErrImageDimensions || err == image.ErrImageEmpty {
+		c.JSON(400, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"status": "error", "message": "internal error"})
+		return
+	}
+
+	viewUrl := fmt.Sprintf("%s/image/view/%s", s.Url, meta.Id)
+	if meta.Watermark {
+		// edit of raw original is watermarked too, editor keeps access to its original
+		token := NewResourceToken(rawImageResource(meta.Id), time.Now().Add(RawImageTokenTTL))
+		viewUrl = fmt.Sprintf("%s?token=%s&expires=%d", viewUrl, token.Value, token.Exipres)
+	}
+
+	c.JSON(200, gin.H{
+		"status":   "ok",
+		"id":       meta.Id,
+		"sourceId": meta.SourceId,
+		"viewUrl":  viewUrl,
+	})
+}
@@ -6,5 +6,5 @@ import (
 
 func (s *server) ImageForm(c *gin.Context) {
 	captcha, _ := s.GuardService.CreateCaptcha(c)
-	s.pages.ImageForm(c, captcha.Id, captcha.ImageBase64)
+	s.pages.ImageForm(c, captcha.Id, captcha.ImageBase64, s.ImageService.WatermarkEnabled())
 }
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"shorty/internal/services/image"
+
+	"github.com/gin-gonic/gin"
+)
+
+type imageInfoResponse struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	Size         int    `json:"size"`
+	Width        int    `json:"width,omitempty"` // dimensions are omitted when unknown
+	Height       int    `json:"height,omitempty"`
+	Format       string `json:"format"`
+	Placeholder  string `json:"placeholder,omitempty"`
+	Status       string `json:"status"`
+	SourceId     string `json:"sourceId,omitempty"`
+	ViewUrl      string `json:"viewUrl"`
+	ImageUrl     string `json:"imageUrl"`
+	ThumbnailUrl string `json:"thumbnailUrl,omitempty"`
+}
+
+func (s *server) ImageInfo(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(404, gin.H{"status": "error", "message": "not found"})
+		return
+	}
+
+	meta, err := s.ImageService.GetImageMetadata(c, id)
+	if err == image.ErrImageNotFound {
+		c.JSON(404, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"status": "error", "message": "internal error"})
+		return
+	}
+
+	resp := imageInfoResponse{
+		Id:          meta.Id,
+		Name:        meta.Name,
+		Size:        meta.Size,
+		Width:       meta.Width,
+		Height:      meta.Height,
+		Format:      string(meta.Format),
+		Placeholder: meta.Placeholder,
+		Status:      string(meta.Status),
+		SourceId:    meta.SourceId,
+		ViewUrl:     fmt.Sprintf("%s/image/view/%s", s.Url, meta.Id),
+		ImageUrl:    s.imageOriginalUrl(meta.Id),
+	}
+	if meta.Status == image.ImageReady {
+		resp.ThumbnailUrl = fmt.Sprintf("%s/i/t/%s", s.Url, meta.Id)
+	}
+
+	c.JSON(200, resp)
+}
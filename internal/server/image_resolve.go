@@ -2,9 +2,11 @@ package server
 
 import (
 	"fmt"
+	"net/http"
 	"shorty/internal/common"
 	"shorty/internal/services/image"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,12 +19,14 @@ func (s *server) ImageResolve(c *gin.Context) {
 		return
 	}
 
-	isThumbnail := false
+	isThumbnail, isRaw := false, false
 	switch c.Param("type") {
 	case "o":
 		isThumbnail = false
 	case "t":
 		isThumbnail = true
+	case "r":
+		isRaw = true
 	default:
 		s.pages.NotFound(c)
 		return
@@ -42,8 +46,13 @@ func (s *server) ImageResolve(c *gin.Context) {
 		token, expiresStr := c.Query("token"), c.Query("expires")
 		expires, _ := strconv.Atoi(expiresStr)
 
+		resource := id
+		if isRaw {
+			resource = rawImageResource(id)
+		}
+
 		expired := int(time.Now().Unix()) > expires
-		valid := CheckResourceToken(id, int64(expires), token)
+		valid := CheckResourceToken(resource, int64(expires), token)
 
 		if expired || !valid {
 			s.Logger.WithContext(c).Info().Msgf("image (id=%s) token(%s) expired, redirecting to view", id, common.MaskSecret(token))
@@ -53,24 +62,119 @@ func (s *server) ImageResolve(c *gin.Context) {
 		}
 	}
 
-	// if oldEtag := c.GetHeader("If-None-Match"); oldEtag == meta.Hash {
-	// 	s.Logger.WithContext(c).Info().Msgf("image (id=%s) hash does not changed", id)
-	// 	c.AbortWithStatus(http.StatusNotModified)
-	// 	return
-	// }
+	if isRaw {
+		if s.serveNotModified(c, meta.OriginalId) {
+			c.Header("Cache-Control", "private, max-age=300")
+			return
+		}
+
+		asset, err := s.ImageService.GetImageRaw(c, id)
+		if err != nil {
+			s.pages.InternalError(c)
+			return
+		}
+		defer asset.Body.Close()
+
+		c.Header("Cache-Control", "private, max-age=300")
+		serveAsset(c, meta.Format.ContentType(), asset)
+		return
+	}
+
+	setCacheHeaders := func() {
+		c.Header("Vary", "Accept")
+		c.Header("Cache-Control", "public, max-age=300")
+	}
 
-	imageBytes, err := s.ImageService.GetImageBytes(c, id, isThumbnail)
+	accepted := negotiateImageFormat(c.GetHeader("Accept"))
+	assetId, err := s.ImageService.GetImageVariantAssetId(c, meta, isThumbnail, accepted)
+	if err == nil && assetId != "" && s.serveNotModified(c, assetId) {
+		setCacheHeaders()
+		return
+	}
+
+	asset, format, err := s.ImageService.GetImageVariant(c, id, isThumbnail, accepted)
 	if err == image.ErrImageNotFound {
 		s.pages.NotFound(c)
 		return
 	}
+	if err == image.ErrImageProcessing {
+		c.Header("Retry-After", "5")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil && accepted != image.FormatJpeg {
+		s.Logger.WithContext(c).Warning().Err(err).Msgf("failed getting image (id=%s) variant, fallback to original", id)
+		asset, err = s.ImageService.GetImage(c, id, isThumbnail)
+		format = image.FormatJpeg
+	}
 	if err != nil {
 		s.pages.InternalError(c)
 		return
 	}
+	defer asset.Body.Close()
 
-	c.Header("ETag", meta.Hash)
-	c.Header("Cache-Control", "public, max-age=300")
+	setCacheHeaders()
+	serveAsset(c, format.ContentType(), asset)
+}
+
+const RawImageTokenTTL = 7 * 24 * time.Hour
+
+// Token resource for original image without watermark, differs from public original
+func rawImageResource(id string) string {
+	return "raw:" + id
+}
+
+// Picks the best image format accepted by client, webp is picked when client prefers it
+// over jpeg, or accepts both equally but names webp more specifically. Jpeg is always
+// acceptable, since every client can show it
+func negotiateImageFormat(accept string) image.Format {
+	webpQ, webpSpecificity := acceptQuality(accept, image.FormatWebp.ContentType())
+	jpegQ, jpegSpecificity := acceptQuality(accept, image.FormatJpeg.ContentType())
+
+	if webpQ > 0 && (webpQ > jpegQ || webpQ == jpegQ && webpSpecificity > jpegSpecificity) {
+		return image.FormatWebp
+	}
+	return image.FormatJpeg
+}
+
+// Returns quality of content type given by the most specific matching media range and its
+// specificity: 2 for exact type, 1 for type wildcard, 0 for any type and -1 for no match.
+// Invalid quality makes range unacceptable
+func acceptQuality(accept, contentType string) (float64, int) {
+	mainType, _, _ := strings.Cut(contentType, "/")
+
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		mediaRange, rangeSpecificity := strings.ToLower(strings.TrimSpace(params[0])), -1
+		switch mediaRange {
+		case contentType:
+			rangeSpecificity = 2
+		case mainType + "/*":
+			rangeSpecificity = 1
+		case "*/*":
+			rangeSpecificity = 0
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(key) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+
+		quality, specificity = q, rangeSpecificity
+	}
 
-	c.Data(200, "image/jpeg", imageBytes)
+	return quality, specificity
 }
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"shorty/internal/services/image"
+	"testing"
+)
+
+func TestNegotiateImageFormat(t *testing.T) {
+	cases := map[string]image.Format{
+		"":                              image.FormatJpeg,
+		"*/*":                           image.FormatJpeg,
+		"image/jpeg,image/*;q=0.8":      image.FormatJpeg,
+		"image/avif,image/webp,*/*":     image.FormatWebp,
+		"image/webp;q=0.9, image/jpeg":  image.FormatJpeg,
+		"image/webp, image/jpeg;q=0.9":  image.FormatWebp,
+		"image/webp;q=0, image/jpeg":    image.FormatJpeg,
+		"text/html, image/webp ; q=0.5": image.FormatWebp,
+		"image/webp;q=abc":              image.FormatJpeg,
+		"image/*":                       image.FormatJpeg,
+		"image/*;q=0.8, image/jpeg;q=0": image.FormatWebp,
+		"image/webp;q=0, */*":           image.FormatJpeg,
+		"*/*;q=0.8, image/jpeg;q=0.5":   image.FormatWebp,
+		"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8": image.FormatWebp,
+		"image/png,image/svg+xml,image/*;q=0.8,video/*;q=0.8,*/*;q=0.5":    image.FormatJpeg,
+	}
+
+	for accept, expected := range cases {
+		if format := negotiateImageFormat(accept); format != expected {
+			t.Fatalf("accept %q: expected %s, got %s", accept, expected, format)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"shorty/internal/common"
 	"shorty/internal/services/image"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
@@ -18,25 +20,45 @@ func (s *server) ImageUpload(c *gin.Context) {
 		return
 	}
 
-	header, err := c.FormFile("image")
+	retention, err := common.ParseRetention(c.PostForm("retention"))
 	if err != nil {
-		log.Error().Err(err).Msg("error getting image from request")
-		c.Redirect(302, "/image?err="+url.QueryEscape(err.Error()))
+		c.Redirect(302, "/image?err="+url.QueryEscape("unknown retention"))
 		return
 	}
 
-	file, err := header.Open()
-	if err != nil {
-		log.Error().Err(err).Msg("error opening image file")
-		s.pages.InternalError(c)
-		return
+	var (
+		file io.ReadSeekCloser
+		name string
+		size int64
+	)
+	if rawUrl := c.PostForm("url"); rawUrl != "" {
+		remote, ok := s.fetchRemote(c, "/image", rawUrl, image.MaxImageSize)
+		if !ok {
+			return
+		}
+		file, name, size = remote, remote.Name, remote.Size
+	} else {
+		header, err := c.FormFile("image")
+		if err != nil {
+			log.Error().Err(err).Msg("error getting image from request")
+			c.Redirect(302, "/image?err="+url.QueryEscape(err.Error()))
+			return
+		}
+
+		file, err = header.Open()
+		if err != nil {
+			log.Error().Err(err).Msg("error opening image file")
+			s.pages.InternalError(c)
+			return
+		}
+		name, size = header.Filename, header.Size
 	}
 	defer file.Close()
 
-	bytes, _ := io.ReadAll(file)
-
-	meta, err := s.ImageService.UploadImage(c, header.Filename, bytes)
-	if err == image.ErrInvalidFormat || err == image.ErrUnsupportedFormat || err == image.ErrImageTooLarge {
+	watermark := c.PostForm("watermark") != ""
+	meta, err := s.ImageService.UploadImage(c, name, file, size, watermark, retention)
+	if err == image.ErrInvalidFormat || err == image.ErrUnsupportedFormat || err == image.ErrImageTooLarge ||
+		err == image.ErrImageEmpty || err == image.ErrImageDimensions || err == image.ErrImageInfected || err == image.ErrScan {
 		log.Error().Err(err).Msg("error getting image from request")
 		c.Redirect(302, "/image?err="+url.QueryEscape(err.Error()))
 		return
@@ -48,5 +70,10 @@ func (s *server) ImageUpload(c *gin.Context) {
 	}
 
 	imgUrl := fmt.Sprintf("/image/view/%s", meta.Id)
+	if meta.Watermark {
+		// only uploader gets access to the original without watermark
+		token := NewResourceToken(rawImageResource(meta.Id), time.Now().Add(RawImageTokenTTL))
+		imgUrl = fmt.Sprintf("%s?token=%s&expires=%d", imgUrl, token.Value, token.Exipres)
+	}
 	c.Redirect(302, imgUrl)
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"shorty/internal/server/pages"
 	"shorty/internal/services/image"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,9 +18,6 @@ func (s *server) ImageView(c *gin.Context) {
 		return
 	}
 
-	year, month, day := time.Now().Add(24 * time.Hour).Date()
-	expiresAt := time.Date(year, month, day, 5, 0, 0, 0, time.Now().Location())
-
 	meta, err := s.ImageService.GetImageMetadata(c, id)
 	if err == image.ErrImageNotFound {
 		s.pages.NotFound(c)
@@ -32,8 +31,21 @@ func (s *server) ImageView(c *gin.Context) {
 	viewUrl := fmt.Sprintf("%s/image/view/%s", s.Url, meta.Id)
 	thumbUrl := fmt.Sprintf("%s/i/t/%s", s.Url, meta.Id)
 
-	token := NewResourceToken(meta.Id, expiresAt)
-	imgUrl := fmt.Sprintf("%s/i/o/%s?token=%s&expires=%d", s.Url, meta.Id, token.Value, token.Exipres)
+	imgUrl := s.imageOriginalUrl(meta.Id)
+
+	sourceUrl := ""
+	if meta.SourceId != "" {
+		sourceUrl = fmt.Sprintf("%s/image/view/%s", s.Url, meta.SourceId)
+	}
+
+	rawUrl := ""
+	token, expires := c.Query("token"), c.Query("expires")
+	if meta.Watermark && token != "" {
+		expiresAt, _ := strconv.Atoi(expires)
+		if int(time.Now().Unix()) <= expiresAt && CheckResourceToken(rawImageResource(meta.Id), int64(expiresAt), token) {
+			rawUrl = fmt.Sprintf("%s/i/r/%s?token=%s&expires=%d", s.Url, meta.Id, token, expiresAt)
+		}
+	}
 
 	s.pages.ImageView(c, pages.ImageViewParams{
 		FileName:     meta.Name,
@@ -41,5 +53,23 @@ func (s *server) ImageView(c *gin.Context) {
 		ViewUrl:      viewUrl,
 		ImageUrl:     imgUrl,
 		ThumbnailUrl: thumbUrl,
+		Width:        meta.Width,
+		Height:       meta.Height,
+		Format:       strings.ToUpper(string(meta.Format)),
+		Placeholder:  meta.Placeholder,
+		Processing:   meta.Status == image.ImageProcessing,
+		RawUrl:       rawUrl,
+		SourceUrl:    sourceUrl,
+		ExpiresAt:    formatExpiresAt(meta.ExpiresAt),
+		Checksum:     meta.Hash,
 	})
 }
+
+// Original image url with token valid until next day morning
+func (s *server) imageOriginalUrl(id string) string {
+	year, month, day := time.Now().Add(24 * time.Hour).Date()
+	expiresAt := time.Date(year, month, day, 5, 0, 0, 0, time.Now().Location())
+
+	token := NewResourceToken(id, expiresAt)
+	return fmt.Sprintf("%s/i/o/%s?token=%s&expires=%d", s.Url, id, token.Value, token.Exipres)
+}
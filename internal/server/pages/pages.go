@@ -48,8 +48,8 @@ func (s *Site) LinkForm(c *gin.Context) {
 	c.Status(200)
 }
 
-func (s *Site) ImageForm(c *gin.Context, id, captchabase64 string) {
-	s.template("views/image_form.html").Execute(c.Writer, ImageFormParams{Id: id, CaptchaBase64: captchabase64})
+func (s *Site) ImageForm(c *gin.Context, id, captchabase64 string, watermarkEnabled bool) {
+	s.template("views/image_form.html").Execute(c.Writer, ImageFormParams{Id: id, CaptchaBase64: captchabase64, WatermarkEnabled: watermarkEnabled})
 	c.Header("Content-Type", "text/html")
 	c.Status(200)
 }
@@ -78,8 +78,26 @@ func (s *Site) FileView(c *gin.Context, p FileViewParams) {
 	c.Status(200)
 }
 
-func (s *Site) FileDownload(c *gin.Context, fileRawUrl string) {
-	s.template("views/file_download.html").Execute(c.Writer, FileDownloadParams{FileRawUrl: fileRawUrl})
+func (s *Site) BundleView(c *gin.Context, p BundleViewParams) {
+	s.template("views/bundle_view.html").Execute(c.Writer, p)
+	c.Header("Content-Type", "text/html")
+	c.Status(200)
+}
+
+func (s *Site) FileDownload(c *gin.Context, p FileDownloadParams) {
+	s.template("views/file_download.html").Execute(c.Writer, p)
+	c.Header("Content-Type", "text/html")
+	c.Status(200)
+}
+
+func (s *Site) PasteForm(c *gin.Context, id, captchabase64 string, languages []string) {
+	s.template("views/paste_form.html").Execute(c.Writer, PasteFormParams{Id: id, CaptchaBase64: captchabase64, Languages: languages})
+	c.Header("Content-Type", "text/html")
+	c.Status(200)
+}
+
+func (s *Site) PasteView(c *gin.Context, p PasteViewParams) {
+	s.template("views/paste_view.html").Execute(c.Writer, p)
 	c.Header("Content-Type", "text/html")
 	c.Status(200)
 }
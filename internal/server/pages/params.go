@@ -1,5 +1,7 @@
 package pages
 
+import "html/template"
+
 type ErrParams struct {
 	Status  int
 	Message string
@@ -11,8 +13,9 @@ type LinkFormParams struct {
 }
 
 type ImageFormParams struct {
-	Id            string
-	CaptchaBase64 string
+	Id               string
+	CaptchaBase64    string
+	WatermarkEnabled bool
 }
 
 type LinkResultParams struct {
@@ -26,6 +29,15 @@ type ImageViewParams struct {
 	ViewUrl      string
 	ImageUrl     string
 	ThumbnailUrl string
+	Width        int
+	Height       int
+	Format       string
+	Placeholder  string
+	Processing   bool
+	RawUrl       string
+	SourceUrl    string
+	ExpiresAt    string
+	Checksum     string
 }
 
 type FileViewParams struct {
@@ -35,8 +47,65 @@ type FileViewParams struct {
 	FileDownloadUrl string
 	CaptchaId       string
 	CaptchaBase64   string
+	ExpiresAt       string
+	Limited         bool
+	DownloadsLeft   int
+	Encrypted       bool
+	Checksum        string
+	Preview         FilePreviewParams
+}
+
+// Preview of file content, only fields of its kind are set
+type FilePreviewParams struct {
+	Kind           string
+	Text           template.HTML
+	ImageUrl       string
+	PdfVersion     string
+	PdfPages       int
+	ArchiveEntries []ArchiveEntryParams
+	Truncated      bool
+}
+
+type ArchiveEntryParams struct {
+	Name   string
+	SizeKB float32
+	Dir    bool
+}
+
+type BundleFileParams struct {
+	FileName    string
+	FileSizeMB  float32
+	FileViewUrl string
+}
+
+type BundleViewParams struct {
+	Files             []BundleFileParams
+	SizeMB            float32
+	BundleViewUrl     string
+	BundleDownloadUrl string
+	CaptchaId         string
+	CaptchaBase64     string
+	ExpiresAt         string
 }
 
 type FileDownloadParams struct {
 	FileRawUrl string
+	FileName   string
+	Encrypted  bool
+}
+
+type PasteFormParams struct {
+	Id            string
+	CaptchaBase64 string
+	Languages     []string
+}
+
+type PasteViewParams struct {
+	Title     string
+	Language  string
+	SizeKB    float32
+	Content   template.HTML
+	ViewUrl   string
+	RawUrl    string
+	ExpiresAt string
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"shorty/internal/common"
+	"shorty/internal/services/pastes"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+func (s *server) PasteCreate(c *gin.Context) {
+	id, token := c.PostForm("id"), c.PostForm("token")
+	err := s.GuardService.CheckCaptcha(c, id, token)
+	if err != nil {
+		c.Redirect(302, "/paste?err="+url.QueryEscape("captcha wrong or expired"))
+		return
+	}
+
+	retention, err := common.ParseRetention(c.PostForm("retention"))
+	if err != nil {
+		c.Redirect(302, "/paste?err="+url.QueryEscape("unknown retention"))
+		return
+	}
+
+	meta, err := s.PasteService.CreatePaste(c, c.PostForm("content"), pastes.PasteOptions{
+		Title:     c.PostForm("title"),
+		Language:  c.PostForm("language"),
+		Retention: retention,
+	})
+	if err == pastes.ErrEmpty || err == pastes.ErrTooBig || err == pastes.ErrOptions {
+		c.Redirect(302, "/paste?err="+url.QueryEscape(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error creating paste")
+		s.pages.InternalError(c)
+		return
+	}
+
+	c.Redirect(302, fmt.Sprintf("/p/%s", meta.Id))
+}
@@ -0,0 +1,12 @@
+package server
+
+import (
+	"shorty/internal/common/highlight"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *server) PasteForm(c *gin.Context) {
+	captcha, _ := s.GuardService.CreateCaptcha(c)
+	s.pages.PasteForm(c, captcha.Id, captcha.ImageBase64, highlight.Languages)
+}
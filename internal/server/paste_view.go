@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"shorty/internal/server/pages"
+	"shorty/internal/services/pastes"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const pasteRawMaxAge = time.Hour
+
+func (s *server) PasteView(c *gin.Context) {
+	paste, ok := s.getPaste(c, s.PasteService.GetHighlightedPaste)
+	if !ok {
+		return
+	}
+
+	s.pages.PasteView(c, pages.PasteViewParams{
+		Title:     paste.Title,
+		Language:  paste.Language,
+		SizeKB:    float32(paste.Size) / 1024,
+		Content:   template.HTML(paste.Highlighted), // escaped by highlighter
+		ViewUrl:   fmt.Sprintf("%s/p/%s", s.Url, paste.Id),
+		RawUrl:    fmt.Sprintf("/p/%s/raw", paste.Id),
+		ExpiresAt: formatExpiresAt(paste.ExpiresAt),
+	})
+}
+
+func (s *server) PasteRaw(c *gin.Context) {
+	paste, ok := s.getPaste(c, s.PasteService.GetPaste)
+	if !ok {
+		return
+	}
+
+	// paste isn't served from cache after it expires
+	maxAge := pasteRawMaxAge
+	if paste.ExpiresAt != nil {
+		maxAge = min(maxAge, time.Until(*paste.ExpiresAt))
+	}
+
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(max(maxAge, 0).Seconds())))
+	c.Data(200, "text/plain; charset=utf-8", []byte(paste.Content))
+}
+
+func (s *server) getPaste(c *gin.Context, get func(ctx context.Context, id string) (*pastes.PasteDTO, error)) (*pastes.PasteDTO, bool) {
+	id := c.Param("id")
+	if id == "" {
+		s.pages.NotFound(c)
+		return nil, false
+	}
+
+	paste, err := get(c, id)
+	if err == pastes.ErrNotFound {
+		s.pages.NotFound(c)
+		return nil, false
+	}
+	if err != nil {
+		s.pages.InternalError(c)
+		return nil, false
+	}
+	return paste, true
+}
@@ -6,15 +6,19 @@ import (
 	"fmt"
 	"io/fs"
 	"net/http"
+	"shorty/internal/common/fetch"
 	"shorty/internal/common/logging"
 	"shorty/internal/common/metrics"
 	"shorty/internal/common/tracing"
 	"shorty/internal/server/middleware"
 	"shorty/internal/server/pages"
+	"shorty/internal/services/assets"
 	"shorty/internal/services/files"
 	"shorty/internal/services/guard"
 	"shorty/internal/services/image"
 	"shorty/internal/services/links"
+	"shorty/internal/services/pastes"
+	"shorty/internal/services/uploads"
 	"sync"
 	"time"
 
@@ -26,6 +30,8 @@ import (
 //go:embed static/*
 var staticFS embed.FS
 
+const shutdownTimeout = 30 * time.Second
+
 type Opts struct {
 	Url          string
 	ApiKey       string
@@ -36,6 +42,11 @@ type Opts struct {
 	GuardService *guard.Service
 	ImageService *image.Service
 	FileService  *files.Service
+	PasteService *pastes.Service
+	AssetStorage *assets.Storage
+	Fetcher      *fetch.Fetcher
+
+	UploadService *uploads.Service
 }
 
 func New(opts Opts) *server {
@@ -47,6 +58,7 @@ func New(opts Opts) *server {
 			mutex:        &sync.Mutex{},
 			statusMetric: opts.Meter.NewGauge("profile_enabled", "Status flag of profiling mode (1-enabled, 0-disabled)"),
 		},
+		context.Background(),
 	}
 }
 
@@ -54,9 +66,14 @@ type server struct {
 	Opts
 	pages    *pages.Site
 	profiler *profiler
+
+	// done when server is stopped, background jobs started by requests are stopped with it
+	ctx context.Context
 }
 
 func (s *server) Run(ctx context.Context, port uint16) error {
+	s.ctx = ctx
+
 	staticDir, err := fs.Sub(staticFS, "static")
 	if err != nil {
 		s.Logger.Fatal().Err(err).Msg("opening static files dir")
@@ -85,26 +102,43 @@ func (s *server) Run(ctx context.Context, port uint16) error {
 	server.Use(tracing.NewMiddleware(s.Tracer))
 	server.Use(middleware.Ratelimit(s.GuardService, s.pages))
 	server.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{s.Url},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type"},
+		AllowOrigins: []string{s.Url},
+		AllowMethods: []string{"GET", "POST", "OPTIONS", "HEAD", "PATCH", "DELETE"},
+		AllowHeaders: []string{
+			"Origin", "Content-Length", "Content-Type",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset",
+		},
+		ExposeHeaders: []string{
+			"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Length", "Upload-Offset", "Upload-Expires", "Shorty-File-Url",
+		},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	apiKeyAuth := func(c *gin.Context) {
+		if c.GetHeader("Authorization") != s.ApiKey {
+			c.AbortWithStatus(403)
+		} else {
+			c.Next()
+		}
+	}
+
 	profGroup := server.Group("/profile")
 	{
-		profGroup.Use(func(c *gin.Context) {
-			if c.GetHeader("Authorization") != s.ApiKey {
-				c.AbortWithStatus(403)
-			} else {
-				c.Next()
-			}
-		})
+		profGroup.Use(apiKeyAuth)
 		profGroup.POST("/start", s.ProfileStart)
 		profGroup.POST("/stop", s.ProfileStop)
 	}
 
+	adminGroup := server.Group("/admin")
+	{
+		adminGroup.Use(apiKeyAuth)
+		adminGroup.POST("/thumbnails/regenerate", s.ThumbnailsRegenerateStart)
+		adminGroup.GET("/thumbnails/regenerate", s.ThumbnailsRegenerateProgress)
+		adminGroup.GET("/assets/shared", s.SharedAssets)
+	}
+
 	server.GET("/link", s.pages.LinkForm)
 	server.POST("/link", s.LinkResult)
 	server.GET("/l/:id", s.LinkResolve)
@@ -113,13 +147,49 @@ func (s *server) Run(ctx context.Context, port uint16) error {
 	server.POST("/image", s.ImageUpload)
 	server.GET("/image/view/:id", s.ImageView)
 	server.GET("/i/:type/:id", s.ImageResolve)
+	server.GET("/api/image/:id", s.ImageInfo)
+	server.POST("/api/image/:id/edit", s.ImageEdit)
 
 	server.GET("/file", s.FileForm)
 	server.POST("/file", s.FileUpload)
 	server.GET("/file/view/:id", s.FileView)
 	server.GET("/file/download/:id", s.FileDownload)
+	server.GET("/file/preview/:id", s.FilePreview)
 	server.GET("/f/:id/:name", s.FileResolve)
+	server.GET("/bundle/view/:id", s.BundleView)
+	server.GET("/bundle/download/:id", s.BundleDownload)
+	server.GET("/b/:id/:name", s.BundleResolve)
+
+	server.GET("/paste", s.PasteForm)
+	server.POST("/paste", s.PasteCreate)
+	server.GET("/p/:id", s.PasteView)
+	server.GET("/p/:id/raw", s.PasteRaw)
+
+	uploadsGroup := server.Group("/api/uploads")
+	{
+		uploadsGroup.Use(tusMiddleware)
+		uploadsGroup.OPTIONS("", s.TusOptions)
+		uploadsGroup.POST("", s.TusCreate)
+		uploadsGroup.HEAD("/:id", s.TusHead)
+		uploadsGroup.PATCH("/:id", s.TusPatch)
+		uploadsGroup.DELETE("/:id", s.TusDelete)
+	}
+
+	httpServer := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: server}
+	go func() {
+		<-ctx.Done()
+		// requests in flight are given time to finish
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			s.Logger.Error().Err(err).Msg("failed shutting down server")
+		}
+	}()
 
 	s.Logger.Info().Msgf("Started server on port %d", port)
-	return server.Run(fmt.Sprintf(":%d", port))
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	s.Logger.Info().Msg("Stopped server")
+	return nil
 }
@@ -1,9 +1,22 @@
 package server
 
 import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
 	"shorty/internal/common"
+	"shorty/internal/common/fetch"
+	"shorty/internal/services/assets"
+	"strings"
 	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
 )
 
 var resourceTokenSecret = common.NewShortId(10)
@@ -30,3 +43,155 @@ func CheckResourceToken(resource string, expiresAt int64, token string) bool {
 	raw := fmt.Sprintf("%s%d%s", resource, expiresAt, resourceTokenSecret)
 	return common.HashsumSHA1(raw) == token
 }
+
+// Serves asset body with range and conditional requests support,
+// ETag is the stored asset hash, so it never changes for asset
+func serveAsset(c *gin.Context, contentType string, asset *assets.AssetDTO) {
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("ETag", assetETag(asset.Hash))
+	setDigestHeaders(c, asset.Hash)
+	http.ServeContent(c.Writer, c.Request, "", asset.CreatedAt, asset.Body)
+}
+
+// Answers 304 when client has current asset, so its object isn't opened for conditional
+// request. Asset which metadata can't be read is served as usual
+func (s *server) serveNotModified(c *gin.Context, assetId string) bool {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+
+	meta, err := s.AssetStorage.GetAssetMetadata(c, assetId)
+	if err != nil || meta == nil || !assetNotModified(c.Request, meta) {
+		return false
+	}
+
+	c.Header("ETag", assetETag(meta.Hash))
+	c.Header("Last-Modified", meta.CreatedAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// Checks conditions like http.ServeContent does: If-None-Match takes precedence over If-Modified-Since
+func assetNotModified(r *http.Request, meta *assets.AssetMetadataDTO) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := assetETag(meta.Hash)
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || meta.CreatedAt.IsZero() {
+		return false
+	}
+	return !meta.CreatedAt.Truncate(time.Second).After(ims)
+}
+
+func assetETag(hash string) string {
+	return fmt.Sprintf(`"%s"`, hash)
+}
+
+// Downloads file for upload form from remote url, redirects back to form on failure
+func (s *server) fetchRemote(c *gin.Context, formPath, rawUrl string, maxSize int64) (*fetch.Remote, bool) {
+	remote, err := s.Fetcher.Fetch(c, rawUrl, maxSize)
+	if err != nil {
+		s.Logger.WithContext(c).Info().Err(err).Msgf("failed fetching remote file from %s", rawUrl)
+
+		msg := err.Error()
+		if errors.Is(err, fetch.ErrFetch) {
+			// details of remote failure aren't shown to user
+			msg = fetch.ErrFetch.Error()
+		}
+		c.Redirect(302, formPath+"?err="+url.QueryEscape(msg))
+		return nil, false
+	}
+	return remote, true
+}
+
+// Sets SHA-512 digest of whole asset in RFC 9530 Repr-Digest and legacy RFC 3230 Digest
+// headers. Digest is of full content, so it stays the same for range requests
+func setDigestHeaders(c *gin.Context, hash string) {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || len(hashBytes) != sha512.Size {
+		return
+	}
+	digest := base64.StdEncoding.EncodeToString(hashBytes)
+	c.Header("Repr-Digest", fmt.Sprintf("sha-512=:%s:", digest))
+	c.Header("Digest", "sha-512="+digest)
+}
+
+// Types of uploaded files, which are safe to render inline from our origin
+var inlineMimeTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/avif": true,
+	"image/bmp":  true,
+	"audio/mpeg": true,
+	"audio/ogg":  true,
+	"audio/wav":  true,
+	"audio/webm": true,
+	"video/mp4":  true,
+	"video/ogg":  true,
+	"video/webm": true,
+}
+
+// Returns content type and disposition type for serving uploaded file. Text is always
+// served as plain text, anything else which may run scripts, like HTML, SVG or PDF,
+// is served as opaque attachment
+func safeContentType(mimeType string) (string, string) {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return common.DefaultMimeType, "attachment"
+	}
+
+	if inlineMimeTypes[mediaType] {
+		return mediaType, "inline"
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return "text/plain; charset=utf-8", "inline"
+	}
+	return common.DefaultMimeType, "attachment"
+}
+
+// Builds RFC 6266 Content-Disposition with ASCII fallback and RFC 5987 encoded UTF-8 filename
+func contentDisposition(dispositionType, name string) string {
+	name = common.SanitizeFileName(name)
+
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	encoded := strings.Builder{}
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, dispositionType, fallback, encoded.String())
+}
+
+func isAttrChar(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+		strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// Formats expiration time for view pages, empty string means never expires
+func formatExpiresAt(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return ""
+	}
+	return expiresAt.Format("2006-01-02 15:04") + " UTC"
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"shorty/internal/services/assets"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveTestAsset(headers map[string]string) *httptest.ResponseRecorder {
+	asset := assets.NewBytesAsset("id", "files", []byte("0123456789"))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	for key, value := range headers {
+		c.Request.Header.Set(key, value)
+	}
+
+	serveAsset(c, "application/octet-stream", asset)
+	c.Writer.WriteHeaderNow()
+	return w
+}
+
+func TestServeAsset(t *testing.T) {
+	w := serveTestAsset(nil)
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatalf("full: unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("full: expected Accept-Ranges header")
+	}
+	etag := w.Header().Get("ETag")
+
+	w = serveTestAsset(map[string]string{"Range": "bytes=2-4"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Fatalf("range: unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Range") != "bytes 2-4/10" {
+		t.Fatalf("range: unexpected Content-Range %q", w.Header().Get("Content-Range"))
+	}
+	// digest is of full content, not of range
+	digest := sha512.Sum512([]byte("0123456789"))
+	expected := "sha-512=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+	if w.Header().Get("Repr-Digest") != expected {
+		t.Fatalf("range: unexpected Repr-Digest %q", w.Header().Get("Repr-Digest"))
+	}
+
+	w = serveTestAsset(map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("etag: expected 304, got %d", w.Code)
+	}
+
+	w = serveTestAsset(map[string]string{"Range": "bytes=20-30"})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("bad range: expected 416, got %d", w.Code)
+	}
+}
+
+func TestSafeContentType(t *testing.T) {
+	cases := map[string][2]string{
+		"image/png":                 {"image/png", "inline"},
+		"text/plain; charset=utf-8": {"text/plain; charset=utf-8", "inline"},
+		"text/html; charset=utf-8":  {"text/plain; charset=utf-8", "inline"},
+		"image/svg+xml":             {"application/octet-stream", "attachment"},
+		"application/pdf":           {"application/octet-stream", "attachment"},
+		"bad type":                  {"application/octet-stream", "attachment"},
+	}
+	for mimeType, expected := range cases {
+		contentType, disposition := safeContentType(mimeType)
+		if contentType != expected[0] || disposition != expected[1] {
+			t.Fatalf("%s: unexpected %s, %s", mimeType, contentType, disposition)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	cases := map[string]string{
+		"report.pdf":        `attachment; filename="report.pdf"; filename*=UTF-8''report.pdf`,
+		"../../etc/passwd":  `attachment; filename="passwd"; filename*=UTF-8''passwd`,
+		"отчёт \"1\".txt":   `attachment; filename="_____ _1_.txt"; filename*=UTF-8''%D0%BE%D1%82%D1%87%D1%91%D1%82%20%221%22.txt`,
+		"a\r\nSet-Cookie:x": `attachment; filename="aSet-Cookie:x"; filename*=UTF-8''aSet-Cookie%3Ax`,
+		"..":                `attachment; filename="file"; filename*=UTF-8''file`,
+	}
+	for name, expected := range cases {
+		if value := contentDisposition("attachment", name); value != expected {
+			t.Fatalf("%q: expected %s, got %s", name, expected, value)
+		}
+	}
+}
+
+func TestAssetNotModified(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	meta := &assets.AssetMetadataDTO{Hash: "abc", CreatedAt: createdAt}
+
+	cases := []struct {
+		headers  map[string]string
+		expected bool
+	}{
+		{nil, false},
+		{map[string]string{"If-None-Match": `"abc"`}, true},
+		{map[string]string{"If-None-Match": `"def", W/"abc"`}, true},
+		{map[string]string{"If-None-Match": "*"}, true},
+		{map[string]string{"If-None-Match": `"def"`}, false},
+		{map[string]string{"If-Modified-Since": createdAt.Format(http.TimeFormat)}, true},
+		{map[string]string{"If-Modified-Since": createdAt.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		{map[string]string{"If-Modified-Since": "yesterday"}, false},
+		// If-Modified-Since is ignored when If-None-Match is present
+		{map[string]string{"If-None-Match": `"def"`, "If-Modified-Since": createdAt.Format(http.TimeFormat)}, false},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for key, value := range tc.headers {
+			r.Header.Set(key, value)
+		}
+		if notModified := assetNotModified(r, meta); notModified != tc.expected {
+			t.Fatalf("headers %v: expected %t, got %t", tc.headers, tc.expected, notModified)
+		}
+	}
+}
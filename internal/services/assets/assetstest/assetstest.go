@@ -0,0 +1,170 @@
+package assetstest
+
+import (
+	"context"
+	"shorty/internal/common/broker"
+	"shorty/internal/services/assets"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Assets repository keeping metadata in memory, unused methods panic.
+// Refs counts unexpired references of asset, referenced assets aren't claimed for deletion
+type Repo struct {
+	assets.MetadataRepo
+	mutex    sync.Mutex
+	Metas    map[string]assets.AssetMetadataDTO
+	Statuses map[string]assets.AssetStatus
+	Refs     map[string]int
+}
+
+func NewRepo() *Repo {
+	return &Repo{
+		Metas:    map[string]assets.AssetMetadataDTO{},
+		Statuses: map[string]assets.AssetStatus{},
+		Refs:     map[string]int{},
+	}
+}
+
+func (r *Repo) SaveAssetsMetadata(ctx context.Context, metas ...assets.AssetMetadataDTO) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, meta := range metas {
+		r.Metas[meta.Id], r.Statuses[meta.Id] = meta, assets.AssetPending
+	}
+	return nil
+}
+
+func (r *Repo) GetAssetMetadata(ctx context.Context, id string) (*assets.AssetMetadataDTO, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	meta, ok := r.Metas[id]
+	if !ok {
+		return nil, nil
+	}
+	return &meta, nil
+}
+
+func (r *Repo) GetAssetDuplicate(ctx context.Context, bucket string, size int, hash string) (*assets.AssetMetadataDTO, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id, meta := range r.Metas {
+		if r.Statuses[id] == assets.AssetCreated && meta.Bucket == bucket && meta.Size == size && meta.Hash == hash {
+			return &meta, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *Repo) SetAssetsStatus(ctx context.Context, status assets.AssetStatus, ids ...string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, id := range ids {
+		r.Statuses[id] = status
+	}
+	return nil
+}
+
+func (r *Repo) SetAssetScanned(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	meta := r.Metas[id]
+	meta.Scanned = true
+	r.Metas[id] = meta
+	return nil
+}
+
+func (r *Repo) ChangeAssetsStatus(ctx context.Context, from, to assets.AssetStatus, ids ...string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	changed := []string{}
+	for _, id := range ids {
+		if r.Statuses[id] == from {
+			r.Statuses[id] = to
+			changed = append(changed, id)
+		}
+	}
+	return changed, nil
+}
+
+func (r *Repo) GetPendingAssets(ctx context.Context, after string, olderThan, uploadsOlderThan time.Duration, limit int) ([]assets.PendingAssetDTO, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := []string{}
+	for id, meta := range r.Metas {
+		age := olderThan
+		if meta.UploadId != "" {
+			age = uploadsOlderThan
+		}
+		if r.Statuses[id] == assets.AssetPending && id > after && time.Since(meta.CreatedAt) > age {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	pending := []assets.PendingAssetDTO{}
+	for _, id := range ids[:min(len(ids), limit)] {
+		pending = append(pending, assets.PendingAssetDTO{AssetMetadataDTO: r.Metas[id], Referenced: r.Refs[id] > 0})
+	}
+	return pending, nil
+}
+
+func (r *Repo) CompletePendingAsset(ctx context.Context, meta assets.AssetMetadataDTO, status assets.AssetStatus) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.Statuses[meta.Id] != assets.AssetPending {
+		return false, nil
+	}
+	r.Metas[meta.Id], r.Statuses[meta.Id] = meta, status
+	return true, nil
+}
+
+func (r *Repo) ClaimUnreferencedAsset(ctx context.Context, id string) (*assets.AssetMetadataDTO, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := r.Statuses[id]
+	if r.Refs[id] > 0 || (status != assets.AssetCreated && status != assets.AssetDeleted) {
+		return nil, nil
+	}
+	r.Statuses[id] = assets.AssetDeleted
+	meta := r.Metas[id]
+	return &meta, nil
+}
+
+// Metadata cache, which never has entries, so metadata is always read from repository
+type Cache struct{}
+
+func (Cache) PutAssetMetadata(ctx context.Context, meta assets.AssetMetadataDTO) error {
+	return nil
+}
+
+func (Cache) GetAssetMetadata(ctx context.Context, id string) (*assets.AssetMetadataDTO, error) {
+	return nil, nil
+}
+
+func (Cache) DeleteAssetMetadata(ctx context.Context, id string) error {
+	return nil
+}
+
+// Broker recording requested previews and released assets, unused methods panic
+type Broker struct {
+	broker.Broker
+	mutex    sync.Mutex
+	Previews []string
+	Released []string
+}
+
+func (b *Broker) PutPreviewsToCreate(ctx context.Context, ids ...string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Previews = append(b.Previews, ids...)
+	return nil
+}
+
+func (b *Broker) PutFilesToDelete(ctx context.Context, ids ...string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Released = append(b.Released, ids...)
+	return nil
+}
@@ -0,0 +1,157 @@
+package assets
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	sweepBatchSize    = 100
+	deletionBatchSize = 10
+	deletionBlockTime = 5 * time.Second
+
+	// Created asset is referenced right after upload, so asset without references is
+	// expired only after grace period, meanwhile its record is being saved
+	UnreferencedGrace = time.Hour
+)
+
+// Enqueues assets, all references to which are expired, and assets left without
+// references for deletion.
+// Asset may be enqueued several times until it is deleted, deletion is idempotent
+func (s *Storage) SweepExpiredAssets(ctx context.Context) (int, error) {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::SweepExpiredAssets")
+	defer span.End()
+
+	count, after := 0, ""
+	for {
+		ids, err := s.metaRepo.GetExpiredAssets(ctx, after, UnreferencedGrace, sweepBatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("failed getting expired assets")
+			return count, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		if err := s.broker.PutFilesToDelete(ctx, ids...); err != nil {
+			log.Error().Err(err).Msg("failed putting expired assets to deletion queue")
+			return count, err
+		}
+
+		count += len(ids)
+		after = ids[len(ids)-1]
+	}
+
+	if count > 0 {
+		log.Info().Msgf("enqueued %d expired assets for deletion", count)
+	}
+	return count, nil
+}
+
+func (s *Storage) RunExpirationSweeper(ctx context.Context, interval time.Duration) {
+	s.logger.Info().Msgf("started expiration sweeper with interval %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.SweepExpiredAssets(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.logger.Info().Msg("stopped expiration sweeper")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Enqueues assets, which are no longer referenced by caller, for deletion.
+// Asset object is removed only when no other record references it
+func (s *Storage) ReleaseAssets(ctx context.Context, ids ...string) error {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::ReleaseAssets")
+	defer span.End()
+
+	if err := s.broker.PutFilesToDelete(ctx, ids...); err != nil {
+		log.Error().Err(err).Msgf("failed putting released assets to deletion queue, ids=%v", ids)
+		return err
+	}
+	return nil
+}
+
+// Removes asset object, when asset has no unexpired references. Asset is marked deleted
+// before its object is removed, so records referencing it are no longer inserted and
+// deduplication that already found it misses and uploads content again
+func (s *Storage) DeleteUnreferencedAsset(ctx context.Context, id string) error {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::DeleteUnreferencedAsset")
+	defer span.End()
+
+	meta, err := s.metaRepo.ClaimUnreferencedAsset(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed claiming asset (id=%s) for deletion", id)
+		return err
+	}
+	if meta == nil {
+		log.Info().Msgf("asset (id=%s) is still referenced or not found, skipping", id)
+		return nil
+	}
+
+	if err := s.metaCache.DeleteAssetMetadata(ctx, id); err != nil {
+		log.Warning().Err(err).Msg("failed deleting metadata from cache")
+	}
+	if err := s.blobs.RemoveFile(ctx, meta.Bucket, meta.ResourceId); err != nil {
+		log.Error().Err(err).Msgf("failed removing asset file, bucket=%s, id=%s", meta.Bucket, id)
+		return err
+	}
+
+	log.Info().Msgf("deleted unreferenced asset, bucket=%s, id=%s", meta.Bucket, id)
+	return nil
+}
+
+// Returns page of assets shared by several records, ordered by id
+func (s *Storage) GetSharedAssets(ctx context.Context, after string, limit int) ([]SharedAssetDTO, error) {
+	ctx, span := s.tracer.Start(ctx, "assets::GetSharedAssets")
+	defer span.End()
+
+	shared, err := s.metaRepo.GetSharedAssets(ctx, after, limit)
+	if err != nil {
+		s.logger.WithContext(ctx).Error().Err(err).Msg("failed getting shared assets")
+		return nil, err
+	}
+	return shared, nil
+}
+
+func (s *Storage) RunDeletionWorker(ctx context.Context, consumer string) {
+	s.logger.Info().Msgf("started assets deletion worker %s", consumer)
+
+	for ctx.Err() == nil {
+		messages, err := s.broker.GetFilesToDelete(ctx, consumer, deletionBatchSize, deletionBlockTime)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("failed reading assets deletion queue")
+			select {
+			case <-ctx.Done():
+			case <-time.After(deletionBlockTime):
+			}
+			continue
+		}
+
+		for _, msg := range messages {
+			if err := s.DeleteUnreferencedAsset(ctx, msg.Value); err != nil {
+				// not acked, will be claimed again later
+				continue
+			}
+
+			if err := s.broker.AckFilesToDelete(ctx, msg.Id); err != nil {
+				s.logger.Error().Err(err).Msgf("failed acking asset (id=%s) deletion", msg.Value)
+			}
+		}
+	}
+
+	s.logger.Info().Msgf("stopped assets deletion worker %s", consumer)
+}
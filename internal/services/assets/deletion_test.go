@@ -0,0 +1,41 @@
+package assets_test
+
+import (
+	"context"
+	"shorty/internal/services/assets"
+	"testing"
+)
+
+func TestDeleteUnreferencedAsset(t *testing.T) {
+	storage, repo, blobs := testStorage(t, 10)
+	ctx := context.Background()
+
+	metas, err := storage.SaveAssets(ctx, "images", []byte("shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := metas[0]
+	object := "images/" + meta.ResourceId
+
+	// deduplicated asset is referenced by two images, one of them expired
+	repo.Refs[meta.Id] = 1
+	if err := storage.DeleteUnreferencedAsset(ctx, meta.Id); err != nil {
+		t.Fatal(err)
+	}
+	if !blobs.objects[object] || repo.Statuses[meta.Id] != assets.AssetCreated {
+		t.Fatal("expected referenced asset to be kept")
+	}
+
+	repo.Refs[meta.Id] = 0
+	if err := storage.DeleteUnreferencedAsset(ctx, meta.Id); err != nil {
+		t.Fatal(err)
+	}
+	if blobs.objects[object] || repo.Statuses[meta.Id] != assets.AssetDeleted {
+		t.Fatal("expected unreferenced asset to be deleted")
+	}
+
+	// repeated deletion is retried removal of already deleted object
+	if err := storage.DeleteUnreferencedAsset(ctx, meta.Id); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,273 @@
+package assets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Assets are encrypted segment by segment, so they can be read from any offset.
+// Every segment is sealed with per-asset data key and nonce derived from segment
+// index, data key is unique for asset so nonces are never reused.
+// Multipart part size is a multiple of segment size, so parts can be encrypted independently
+const (
+	encryptionSegmentSize = 64 * 1024
+	encryptionTagSize     = 16
+	encryptionNonceSize   = 12
+	dataKeySize           = 32
+)
+
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Master keys by id. Data keys are encrypted by active master key, other keys are
+// kept to decrypt data keys of assets, which were not re-encrypted after rotation
+type Keyring struct {
+	activeId string
+	keys     map[string]cipher.AEAD
+}
+
+func NewKeyring(activeId string, keys map[string][]byte) (*Keyring, error) {
+	keyring := &Keyring{activeId: activeId, keys: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("bad master key %s: %w", id, err)
+		}
+		keyring.keys[id] = aead
+	}
+	if _, ok := keyring.keys[activeId]; !ok {
+		return nil, fmt.Errorf("no active master key %s", activeId)
+	}
+
+	return keyring, nil
+}
+
+// Parses master keys in format "id1:base64key1,id2:base64key2"
+func ParseMasterKeys(value string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("bad master key format")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("bad master key %s encoding", id)
+		}
+		keys[id] = key
+	}
+
+	return keys, nil
+}
+
+func (k *Keyring) ActiveKeyId() string {
+	return k.activeId
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns new data key with its copy encrypted by active master key
+func (k *Keyring) newDataKey() ([]byte, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := k.wrapDataKey(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, wrapped, nil
+}
+
+// Key id is authenticated, so wrapped key can't be moved between master keys
+func (k *Keyring) wrapDataKey(dataKey []byte) ([]byte, error) {
+	return sealRandom(k.keys[k.activeId], dataKey, []byte(k.activeId))
+}
+
+func (k *Keyring) unwrapDataKey(keyId string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyId]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return openRandom(aead, wrapped, []byte(keyId))
+}
+
+// Seals data with random nonce, which is prepended to result
+func sealRandom(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, additional), nil
+}
+
+func openRandom(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+func segmentNonce(index int64) []byte {
+	nonce := make([]byte, encryptionNonceSize)
+	binary.BigEndian.PutUint64(nonce[encryptionNonceSize-8:], uint64(index))
+	return nonce
+}
+
+// Size of encrypted object for plaintext of given size
+func encryptedSize(size int64) int64 {
+	segments := (size + encryptionSegmentSize - 1) / encryptionSegmentSize
+	return size + segments*encryptionTagSize
+}
+
+// Encrypts stream segment by segment, starting from given segment index
+type encryptingReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	index   int64
+	segment []byte
+	sealed  []byte
+	pending []byte
+	err     error
+}
+
+func newEncryptingReader(r io.Reader, aead cipher.AEAD, index int64) *encryptingReader {
+	return &encryptingReader{
+		r:       r,
+		aead:    aead,
+		index:   index,
+		segment: make([]byte, encryptionSegmentSize),
+		sealed:  make([]byte, 0, encryptionSegmentSize+encryptionTagSize),
+	}
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+
+		n, err := io.ReadFull(e.r, e.segment)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		e.err = err
+		if n > 0 {
+			e.pending = e.aead.Seal(e.sealed[:0], segmentNonce(e.index), e.segment[:n], nil)
+			e.index++
+		}
+	}
+
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// Decrypts asset of given plaintext size, segments are read lazily, so seeking
+// only moves offset and underlying body is seeked on the next read
+type decryptingReader struct {
+	body    io.ReadSeekCloser
+	aead    cipher.AEAD
+	size    int64
+	offset  int64
+	bodyPos int64
+
+	index   int64
+	sealed  []byte
+	segment []byte
+}
+
+func newDecryptingReader(body io.ReadSeekCloser, aead cipher.AEAD, size int64) *decryptingReader {
+	return &decryptingReader{
+		body:   body,
+		aead:   aead,
+		size:   size,
+		index:  -1,
+		sealed: make([]byte, encryptionSegmentSize+encryptionTagSize),
+	}
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+
+	index := d.offset / encryptionSegmentSize
+	if index != d.index {
+		if err := d.readSegment(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.segment[d.offset-index*encryptionSegmentSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+func (d *decryptingReader) readSegment(index int64) error {
+	pos := index * (encryptionSegmentSize + encryptionTagSize)
+	if pos != d.bodyPos {
+		if _, err := d.body.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		d.bodyPos = pos
+	}
+
+	length := min(encryptionSegmentSize, d.size-index*encryptionSegmentSize) + encryptionTagSize
+	n, err := io.ReadFull(d.body, d.sealed[:length])
+	d.bodyPos += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	d.index = -1
+	d.segment, err = d.aead.Open(d.sealed[:0], segmentNonce(index), d.sealed[:length], nil)
+	if err != nil {
+		return fmt.Errorf("failed decrypting asset segment %d: %w", index, err)
+	}
+	d.index = index
+	return nil
+}
+
+func (d *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.body.Close()
+}
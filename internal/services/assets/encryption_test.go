@@ -0,0 +1,136 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func testKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func encryptBytes(t *testing.T, plaintext []byte) ([]byte, *decryptingReader) {
+	t.Helper()
+	dataKey, _, err := testKeyring(t).newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, _ := newAEAD(dataKey)
+
+	sealed, err := io.ReadAll(newEncryptingReader(bytes.NewReader(plaintext), aead, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(sealed)) != encryptedSize(int64(len(plaintext))) {
+		t.Fatalf("expected encrypted size %d, got %d", encryptedSize(int64(len(plaintext))), len(sealed))
+	}
+
+	return sealed, newDecryptingReader(nopCloser{bytes.NewReader(sealed)}, aead, int64(len(plaintext)))
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, encryptionSegmentSize, 3*encryptionSegmentSize + 17} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		_, r := encryptBytes(t, plaintext)
+		decrypted, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("size %d: decrypted content mismatch (err=%v)", size, err)
+		}
+	}
+}
+
+func TestDecryptingReaderSeek(t *testing.T) {
+	plaintext := make([]byte, 2*encryptionSegmentSize+100)
+	rand.Read(plaintext)
+	_, r := encryptBytes(t, plaintext)
+
+	for _, offset := range []int64{encryptionSegmentSize + 5, 10, int64(len(plaintext)) - 1} {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		chunk := make([]byte, 1)
+		if _, err := io.ReadFull(r, chunk); err != nil || chunk[0] != plaintext[offset] {
+			t.Fatalf("offset %d: read mismatch (err=%v)", offset, err)
+		}
+	}
+
+	if size, _ := r.Seek(0, io.SeekEnd); size != int64(len(plaintext)) {
+		t.Fatalf("expected size %d, got %d", len(plaintext), size)
+	}
+}
+
+func TestDecryptingReaderTampered(t *testing.T) {
+	sealed, r := encryptBytes(t, []byte("some secret content"))
+	sealed[0] ^= 1
+
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatalf("expected error for tampered ciphertext")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	old, _ := NewKeyring("k1", map[string][]byte{"k1": oldKey})
+	dataKey, wrapped, err := old.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := NewKeyring("k2", map[string][]byte{"k1": oldKey, "k2": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := rotated.unwrapDataKey("k1", wrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("failed unwrapping data key with previous master key (err=%v)", err)
+	}
+	if _, err := rotated.unwrapDataKey("k2", wrapped); err == nil {
+		t.Fatalf("expected error for data key wrapped by other master key")
+	}
+	if _, err := old.unwrapDataKey("k3", wrapped); err != ErrUnknownKey {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	keys, err := ParseMasterKeys("k1:AQID, k2:BAUG")
+	if err != nil || len(keys) != 2 || !bytes.Equal(keys["k2"], []byte{4, 5, 6}) {
+		t.Fatalf("unexpected keys %v (err=%v)", keys, err)
+	}
+	if _, err := ParseMasterKeys("k1"); err == nil {
+		t.Fatalf("expected error for key without id")
+	}
+}
+
+// Parts of multipart upload are encrypted separately and concatenated by storage
+func TestEncryptionParts(t *testing.T) {
+	aead, _ := newAEAD(bytes.Repeat([]byte{3}, 32))
+	plaintext := make([]byte, 2*encryptionSegmentSize+10)
+	rand.Read(plaintext)
+
+	first, _ := io.ReadAll(newEncryptingReader(bytes.NewReader(plaintext[:encryptionSegmentSize]), aead, 0))
+	second, _ := io.ReadAll(newEncryptingReader(bytes.NewReader(plaintext[encryptionSegmentSize:]), aead, 1))
+
+	r := newDecryptingReader(nopCloser{bytes.NewReader(append(first, second...))}, aead, int64(len(plaintext)))
+	decrypted, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted content mismatch (err=%v)", err)
+	}
+}
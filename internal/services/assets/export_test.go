@@ -0,0 +1,10 @@
+package assets
+
+import "context"
+
+// Unexported helpers used by external tests of the package
+var MultipartTailId = multipartTailId
+
+func (s *Storage) CompletePending(ctx context.Context, status AssetStatus, ids ...string) error {
+	return s.completePending(ctx, status, ids...)
+}
@@ -2,15 +2,40 @@ package assets
 
 import (
 	"context"
+	"io"
+	"time"
 )
 
 type MetadataRepo interface {
 	SaveAssetsMetadata(ctx context.Context, metas ...AssetMetadataDTO) error
 	GetAssetMetadata(ctx context.Context, id string) (*AssetMetadataDTO, error)
+	GetAssetDuplicate(ctx context.Context, bucket string, size int, hash string) (*AssetMetadataDTO, error)
 	SetAssetsStatus(ctx context.Context, status AssetStatus, ids ...string) error
+	SetAssetScanned(ctx context.Context, id string) error
+	ChangeAssetsStatus(ctx context.Context, from, to AssetStatus, ids ...string) ([]string, error)
+	GetPendingAssets(ctx context.Context, after string, olderThan, uploadsOlderThan time.Duration, limit int) ([]PendingAssetDTO, error)
+	CompletePendingAsset(ctx context.Context, meta AssetMetadataDTO, status AssetStatus) (bool, error)
+	GetExpiredAssets(ctx context.Context, after string, unreferencedFor time.Duration, limit int) ([]string, error)
+	ClaimUnreferencedAsset(ctx context.Context, id string) (*AssetMetadataDTO, error)
+	GetSharedAssets(ctx context.Context, after string, limit int) ([]SharedAssetDTO, error)
+	GetAssetsToReencrypt(ctx context.Context, keyId string, after string, limit int) ([]AssetMetadataDTO, error)
+	UpdateAssetDataKey(ctx context.Context, id, keyId string, dataKey []byte) error
 }
 
 type MetadataCache interface {
 	PutAssetMetadata(ctx context.Context, meta AssetMetadataDTO) error
 	GetAssetMetadata(ctx context.Context, id string) (*AssetMetadataDTO, error)
+	DeleteAssetMetadata(ctx context.Context, id string) error
+}
+
+// Object storage of asset content, objects are addressed by bucket and id.
+// Multipart upload is assembled from parts ordered by part number on completion
+type BlobStore interface {
+	SaveFile(ctx context.Context, bucket, id string, r io.Reader, size int64) error
+	GetFile(ctx context.Context, bucket, id string) (io.ReadSeekCloser, error)
+	RemoveFile(ctx context.Context, bucket, id string) error
+	NewMultipart(ctx context.Context, bucket, id string) (string, error)
+	PutPart(ctx context.Context, bucket, id, uploadId string, partNumber int, r io.Reader, size int64) error
+	CompleteMultipart(ctx context.Context, bucket, id, uploadId string) error
+	AbortMultipart(ctx context.Context, bucket, id, uploadId string) error
 }
@@ -1,19 +1,70 @@
 package assets
 
+import (
+	"bytes"
+	"io"
+	"shorty/internal/common"
+	"time"
+)
+
 type AssetMetadataDTO struct {
 	Id         string
 	ResourceId string
 	Size       int
 	Hash       string
 	Bucket     string
+	CreatedAt  time.Time
+	KeyId      string // master key id, empty for assets stored as plaintext
+	DataKey    []byte // data key encrypted by master key
+	UploadId   string // multipart upload, asset is assembled from
+	Scanned    bool   // content was scanned for malware, duplicates of unscanned asset are scanned again
 }
 
+// Asset with lazily read body, body must be closed by caller
 type AssetDTO struct {
-	Id     string
-	Size   int
-	Hash   string
-	Bucket string
-	Bytes  []byte
+	Id        string
+	Size      int
+	Hash      string
+	Bucket    string
+	CreatedAt time.Time
+	Body      io.ReadSeekCloser
+}
+
+type bytesBody struct {
+	*bytes.Reader
+}
+
+func (bytesBody) Close() error {
+	return nil
+}
+
+// Wraps already read asset bytes, so they can be returned as asset
+func NewBytesAsset(id, bucket string, assetBytes []byte) *AssetDTO {
+	return &AssetDTO{
+		Id:        id,
+		Size:      len(assetBytes),
+		Hash:      common.NewAssetHash(assetBytes),
+		Bucket:    bucket,
+		CreatedAt: time.Now(),
+		Body:      bytesBody{bytes.NewReader(assetBytes)},
+	}
+}
+
+// Asset left pending by unfinished saving. Referenced pending asset is in use
+// by some record, so it must not be reaped
+type PendingAssetDTO struct {
+	AssetMetadataDTO
+	Referenced bool
+}
+
+// Asset referenced by several records, References include expired ones
+type SharedAssetDTO struct {
+	Id             string
+	Bucket         string
+	Size           int
+	CreatedAt      time.Time
+	References     int
+	LiveReferences int
 }
 
 type AssetStatus string
@@ -22,4 +73,6 @@ const (
 	AssetPending AssetStatus = "pending"
 	AssetCreated AssetStatus = "created"
 	AssetDeleted AssetStatus = "deleted"
+	// Asset with detected malware, it is kept for review and never served or reused
+	AssetQuarantined AssetStatus = "quarantined"
 )
@@ -0,0 +1,337 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+	"shorty/internal/common"
+	"time"
+)
+
+// S3 requires all parts of multipart upload except the last one to be at least 5MB
+const MultipartPartSize = 5 * 1024 * 1024
+
+// State of multipart asset upload, it is persisted by caller between writes.
+// Written bytes which don't fill a whole part are kept in separate tail object.
+// Pending asset is inserted on start, so abandoned upload is reaped by janitor
+type MultipartDTO struct {
+	AssetId    string
+	Bucket     string
+	ResourceId string
+	UploadId   string
+	Offset     int64
+	Parts      int
+	TailSize   int64
+	HashState  []byte
+	KeyId      string
+	DataKey    []byte
+}
+
+func multipartTailId(resourceId string) string {
+	return resourceId + ".tail"
+}
+
+func restoreHasher(state []byte) (hash.Hash, error) {
+	hasher := common.NewAssetHasher()
+	if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+func saveHasher(hasher hash.Hash) []byte {
+	state, _ := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	return state
+}
+
+// Puts next part, encrypted part continues segments of previous parts
+func (s *Storage) putPart(ctx context.Context, upload *MultipartDTO, aead cipher.AEAD, r io.Reader, size int64) error {
+	if aead != nil {
+		index := int64(upload.Parts) * (MultipartPartSize / encryptionSegmentSize)
+		r, size = newEncryptingReader(r, aead, index), encryptedSize(size)
+	}
+	return s.blobs.PutPart(ctx, upload.Bucket, upload.ResourceId, upload.UploadId, upload.Parts+1, r, size)
+}
+
+// Tail is rewritten on every write, so it is sealed as a whole with random nonce
+func (s *Storage) saveTail(ctx context.Context, upload *MultipartDTO, aead cipher.AEAD, tail []byte) error {
+	if aead != nil {
+		sealed, err := sealRandom(aead, tail, nil)
+		if err != nil {
+			return err
+		}
+		tail = sealed
+	}
+	return s.blobs.SaveFile(ctx, upload.Bucket, multipartTailId(upload.ResourceId), bytes.NewReader(tail), int64(len(tail)))
+}
+
+func (s *Storage) getTail(ctx context.Context, upload *MultipartDTO, aead cipher.AEAD) ([]byte, error) {
+	body, err := s.blobs.GetFile(ctx, upload.Bucket, multipartTailId(upload.ResourceId))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	tail, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if aead != nil {
+		tail, err = openRandom(aead, tail, nil)
+	}
+	if err == nil && int64(len(tail)) != upload.TailSize {
+		err = fmt.Errorf("multipart tail size mismatch")
+	}
+	return tail, err
+}
+
+// Removes parts and tail of multipart upload, upload may be already completed
+func (s *Storage) removeMultipart(ctx context.Context, bucket, resourceId, uploadId string) {
+	if err := s.blobs.AbortMultipart(ctx, bucket, resourceId, uploadId); err != nil {
+		s.logger.WithContext(ctx).Info().Err(err).Msgf("failed aborting multipart upload, resource=%s", resourceId)
+	}
+	s.removeFile(ctx, bucket, multipartTailId(resourceId))
+}
+
+func (s *Storage) StartMultipart(ctx context.Context, bucket string) (*MultipartDTO, error) {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::StartMultipart")
+	defer span.End()
+
+	upload := &MultipartDTO{
+		Bucket:     bucket,
+		ResourceId: common.NewShortId(32),
+		HashState:  saveHasher(common.NewAssetHasher()),
+	}
+
+	uploadId, err := s.blobs.NewMultipart(ctx, bucket, upload.ResourceId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed starting multipart upload, bucket=%s", bucket)
+		return nil, err
+	}
+	upload.UploadId = uploadId
+
+	if s.keyring != nil {
+		_, wrapped, err := s.keyring.newDataKey()
+		if err != nil {
+			log.Error().Err(err).Msg("failed creating multipart data key")
+			return nil, err
+		}
+		upload.KeyId, upload.DataKey = s.keyring.ActiveKeyId(), wrapped
+	}
+
+	// size and hash are filled on completion
+	meta := AssetMetadataDTO{
+		Id:         common.NewShortId(32),
+		ResourceId: upload.ResourceId,
+		Bucket:     bucket,
+		CreatedAt:  time.Now().UTC(),
+		KeyId:      upload.KeyId,
+		DataKey:    upload.DataKey,
+		UploadId:   upload.UploadId,
+	}
+	if err := s.metaRepo.SaveAssetsMetadata(ctx, meta); err != nil {
+		log.Error().Err(err).Msg("failed saving multipart asset metadata")
+		if err := s.blobs.AbortMultipart(ctx, bucket, upload.ResourceId, upload.UploadId); err != nil {
+			log.Error().Err(err).Msgf("failed aborting multipart upload, resource=%s", upload.ResourceId)
+		}
+		return nil, err
+	}
+	upload.AssetId = meta.Id
+
+	log.Info().Msgf("started multipart upload, bucket=%s, resource=%s", bucket, upload.ResourceId)
+	return upload, nil
+}
+
+// Appends stream to multipart upload. Upload state is updated after every
+// persisted part, so it stays consistent even if error is returned
+func (s *Storage) WriteMultipart(ctx context.Context, upload *MultipartDTO, r io.Reader) error {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::WriteMultipart")
+	defer span.End()
+
+	hasher, err := restoreHasher(upload.HashState)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed restoring multipart hash state, resource=%s", upload.ResourceId)
+		return err
+	}
+	aead, err := s.assetCipher(upload.KeyId, upload.DataKey)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed decrypting multipart data key, resource=%s", upload.ResourceId)
+		return err
+	}
+
+	buf := make([]byte, MultipartPartSize)
+	filled := 0
+	if upload.TailSize > 0 {
+		tail, err := s.getTail(ctx, upload, aead)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed reading multipart tail, resource=%s", upload.ResourceId)
+			return err
+		}
+		filled = copy(buf, tail)
+	}
+	// bytes of buf before this position are already hashed and counted in offset
+	hashed := filled
+
+	var readErr error
+	for {
+		n, err := io.ReadFull(r, buf[filled:])
+		filled += n
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			readErr = err
+		}
+		if filled < len(buf) {
+			break
+		}
+
+		if err := s.putPart(ctx, upload, aead, bytes.NewReader(buf), int64(len(buf))); err != nil {
+			log.Error().Err(err).Msgf("failed putting multipart part, resource=%s", upload.ResourceId)
+			return err
+		}
+		hasher.Write(buf[hashed:])
+		upload.HashState = saveHasher(hasher)
+		upload.Offset += int64(len(buf) - hashed)
+		upload.Parts++
+		upload.TailSize = 0
+		filled, hashed = 0, 0
+	}
+
+	if filled > hashed {
+		if err := s.saveTail(ctx, upload, aead, buf[:filled]); err != nil {
+			log.Error().Err(err).Msgf("failed saving multipart tail, resource=%s", upload.ResourceId)
+			return err
+		}
+		hasher.Write(buf[hashed:filled])
+		upload.HashState = saveHasher(hasher)
+		upload.Offset += int64(filled - hashed)
+		upload.TailSize = int64(filled)
+	}
+
+	if readErr != nil {
+		log.Info().Msgf("multipart stream interrupted, resource=%s, offset=%d", upload.ResourceId, upload.Offset)
+		return readErr
+	}
+
+	return nil
+}
+
+// Puts tail as the last part, completes upload and creates asset from it.
+// Upload with content of existing asset is aborted and existing asset is returned
+func (s *Storage) CompleteMultipart(ctx context.Context, upload *MultipartDTO) (*AssetMetadataDTO, error) {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::CompleteMultipart")
+	defer span.End()
+
+	hasher, err := restoreHasher(upload.HashState)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed restoring multipart hash state, resource=%s", upload.ResourceId)
+		return nil, err
+	}
+	aead, err := s.assetCipher(upload.KeyId, upload.DataKey)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed decrypting multipart data key, resource=%s", upload.ResourceId)
+		return nil, err
+	}
+
+	meta := AssetMetadataDTO{
+		Id:         upload.AssetId,
+		ResourceId: upload.ResourceId,
+		Size:       int(upload.Offset),
+		Hash:       common.AssetHasherSum(hasher),
+		Bucket:     upload.Bucket,
+		CreatedAt:  time.Now().UTC(),
+		KeyId:      upload.KeyId,
+		DataKey:    upload.DataKey,
+		UploadId:   upload.UploadId,
+	}
+
+	// upload was started before its asset was inserted on start
+	if meta.Id == "" {
+		meta.Id = common.NewShortId(32)
+		if err := s.metaRepo.SaveAssetsMetadata(ctx, meta); err != nil {
+			log.Error().Err(err).Msg("failed saving multipart asset metadata")
+			return nil, err
+		}
+		upload.AssetId = meta.Id
+	}
+
+	// every byte is hashed on write, so duplicate is found before upload is completed
+	duplicate, err := s.GetAssetDuplicate(ctx, meta.Bucket, meta.Size, meta.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if duplicate != nil {
+		log.Info().Msgf("found existing asset with same hash (id=%s), aborting uploaded copy", duplicate.Id)
+		s.AbortMultipart(ctx, upload)
+		return duplicate, nil
+	}
+
+	tailId := multipartTailId(upload.ResourceId)
+	if upload.TailSize > 0 || upload.Parts == 0 {
+		var tail []byte
+		if upload.TailSize > 0 {
+			tail, err = s.getTail(ctx, upload, aead)
+			if err != nil {
+				log.Error().Err(err).Msgf("failed reading multipart tail, resource=%s", upload.ResourceId)
+				return nil, err
+			}
+		}
+		if err := s.putPart(ctx, upload, aead, bytes.NewReader(tail), int64(len(tail))); err != nil {
+			log.Error().Err(err).Msgf("failed putting multipart last part, resource=%s", upload.ResourceId)
+			return nil, err
+		}
+		upload.Parts++
+		upload.TailSize = 0
+	}
+
+	if err := s.blobs.CompleteMultipart(ctx, upload.Bucket, upload.ResourceId, upload.UploadId); err != nil {
+		log.Error().Err(err).Msgf("failed completing multipart upload, resource=%s", upload.ResourceId)
+		return nil, err
+	}
+	s.removeFile(ctx, upload.Bucket, tailId)
+
+	if err := s.completePendingAsset(ctx, meta, AssetCreated); err != nil {
+		log.Error().Err(err).Msg("failed updating asset status")
+		s.abortPending(ctx, upload.Bucket, []string{meta.Id}, []string{meta.ResourceId})
+		return nil, err
+	}
+
+	log.Info().Msgf("saved multipart asset, bucket=%s, id=%s", upload.Bucket, meta.Id)
+
+	if err := s.metaCache.PutAssetMetadata(ctx, meta); err != nil {
+		s.logger.Warning().Err(err).Msg("failed putting metadata to cache")
+	}
+
+	return &meta, nil
+}
+
+func (s *Storage) AbortMultipart(ctx context.Context, upload *MultipartDTO) error {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::AbortMultipart")
+	defer span.End()
+
+	if upload.TailSize > 0 {
+		s.removeFile(ctx, upload.Bucket, multipartTailId(upload.ResourceId))
+	}
+	if err := s.blobs.AbortMultipart(ctx, upload.Bucket, upload.ResourceId, upload.UploadId); err != nil {
+		log.Error().Err(err).Msgf("failed aborting multipart upload, resource=%s", upload.ResourceId)
+		return fmt.Errorf("failed aborting multipart upload: %w", err)
+	}
+
+	if err := s.metaRepo.SetAssetsStatus(ctx, AssetDeleted, upload.AssetId); err != nil {
+		// asset is left pending and reaped by janitor
+		log.Warning().Err(err).Msgf("failed marking aborted multipart asset (id=%s) deleted", upload.AssetId)
+	}
+
+	log.Info().Msgf("aborted multipart upload, bucket=%s, resource=%s", upload.Bucket, upload.ResourceId)
+	return nil
+}
@@ -0,0 +1,141 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Pending asset was reaped by janitor before saving was completed
+var ErrReaped = errors.New("pending asset was reaped")
+
+const (
+	DefaultPendingTTL = time.Hour
+	// Multipart upload may be written for this long after start,
+	// its pending asset is reaped only after it and pending ttl
+	MultipartTTL = 24 * time.Hour
+
+	reapBatchSize = 100
+)
+
+// Moves pending assets to final status. Status is changed only while assets are
+// pending, so asset reaped by janitor in the meantime is never resurrected
+func (s *Storage) completePending(ctx context.Context, status AssetStatus, ids ...string) error {
+	changed, err := s.metaRepo.ChangeAssetsStatus(ctx, AssetPending, status, ids...)
+	if err != nil {
+		return err
+	}
+	if len(changed) != len(ids) {
+		return ErrReaped
+	}
+	return nil
+}
+
+// Completes single pending asset, its size and hash are known only after object is written
+func (s *Storage) completePendingAsset(ctx context.Context, meta AssetMetadataDTO, status AssetStatus) error {
+	completed, err := s.metaRepo.CompletePendingAsset(ctx, meta, status)
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return ErrReaped
+	}
+	return nil
+}
+
+// Compensates failed saving: removes written objects and marks assets deleted.
+// It isn't stopped by cancelled request, assets left pending are reaped by janitor
+func (s *Storage) abortPending(ctx context.Context, bucket string, ids, resourceIds []string) {
+	log := s.logger.WithContext(ctx)
+	ctx = context.WithoutCancel(ctx)
+
+	for _, resourceId := range resourceIds {
+		s.removeFile(ctx, bucket, resourceId)
+	}
+	if err := s.metaRepo.SetAssetsStatus(ctx, AssetDeleted, ids...); err != nil {
+		log.Error().Err(err).Msgf("failed marking aborted assets deleted, ids=%v", ids)
+		return
+	}
+
+	log.Info().Msgf("aborted saving assets, bucket=%s, ids=%v", bucket, ids)
+}
+
+// Reaps assets left pending longer than ttl by crashed or failed saving. Assets are
+// claimed as deleted before objects are removed, so concurrent saving fails to complete.
+// Referenced pending assets are never reaped, their objects are in use
+func (s *Storage) ReapPendingAssets(ctx context.Context, ttl time.Duration) (int, error) {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::ReapPendingAssets")
+	defer span.End()
+
+	count, after := 0, ""
+	for {
+		pending, err := s.metaRepo.GetPendingAssets(ctx, after, ttl, ttl+MultipartTTL, reapBatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("failed getting pending assets")
+			return count, err
+		}
+		if len(pending) == 0 {
+			break
+		}
+		after = pending[len(pending)-1].Id
+
+		ids := []string{}
+		for _, asset := range pending {
+			if asset.Referenced {
+				log.Warning().Msgf("skipping referenced pending asset (id=%s)", asset.Id)
+				continue
+			}
+			ids = append(ids, asset.Id)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		claimed, err := s.metaRepo.ChangeAssetsStatus(ctx, AssetPending, AssetDeleted, ids...)
+		if err != nil {
+			log.Error().Err(err).Msg("failed claiming pending assets")
+			return count, err
+		}
+
+		claimedIds := map[string]bool{}
+		for _, id := range claimed {
+			claimedIds[id] = true
+		}
+		for _, asset := range pending {
+			if !claimedIds[asset.Id] {
+				continue
+			}
+			// abandoned multipart upload keeps its parts and tail until it is aborted
+			if asset.UploadId != "" {
+				s.removeMultipart(ctx, asset.Bucket, asset.ResourceId, asset.UploadId)
+			}
+			s.removeFile(ctx, asset.Bucket, asset.ResourceId)
+		}
+		count += len(claimed)
+	}
+
+	if count > 0 {
+		log.Info().Msgf("reaped %d pending assets", count)
+	}
+	return count, nil
+}
+
+func (s *Storage) RunPendingJanitor(ctx context.Context, interval, ttl time.Duration) {
+	s.logger.Info().Msgf("started pending assets janitor with interval %s, ttl %s", interval, ttl)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.ReapPendingAssets(ctx, ttl)
+
+		select {
+		case <-ctx.Done():
+			s.logger.Info().Msg("stopped pending assets janitor")
+			return
+		case <-ticker.C:
+		}
+	}
+}
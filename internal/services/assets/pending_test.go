@@ -0,0 +1,164 @@
+package assets_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"shorty/internal/common/logging"
+	"shorty/internal/services/assets"
+	"shorty/internal/services/assets/assetstest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Blob store, which fails saving after given number of objects
+type failingBlobs struct {
+	assets.BlobStore
+	objects  map[string]bool
+	failFrom int
+}
+
+func (b *failingBlobs) SaveFile(ctx context.Context, bucket, id string, r io.Reader, size int64) error {
+	if len(b.objects) >= b.failFrom {
+		return errors.New("storage is down")
+	}
+	if r != nil {
+		io.Copy(io.Discard, r)
+	}
+	b.objects[bucket+"/"+id] = true
+	return nil
+}
+
+func (b *failingBlobs) RemoveFile(ctx context.Context, bucket, id string) error {
+	delete(b.objects, bucket+"/"+id)
+	return nil
+}
+
+func (b *failingBlobs) AbortMultipart(ctx context.Context, bucket, id, uploadId string) error {
+	delete(b.objects, bucket+"/"+uploadId+"/parts")
+	return nil
+}
+
+func testStorage(t *testing.T, failFrom int) (*assets.Storage, *assetstest.Repo, *failingBlobs) {
+	t.Helper()
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := assetstest.NewRepo()
+	blobs := &failingBlobs{objects: map[string]bool{}, failFrom: failFrom}
+	storage := assets.NewStorage(repo, assetstest.Cache{}, blobs, nil, nil, logger, noop.NewTracerProvider().Tracer("test"))
+	return storage, repo, blobs
+}
+
+func TestSaveAssetsCompensation(t *testing.T) {
+	storage, repo, blobs := testStorage(t, 2)
+
+	if _, err := storage.SaveAssets(context.Background(), "files", []byte("a"), []byte("b"), []byte("c")); err == nil {
+		t.Fatal("expected saving error")
+	}
+	if len(blobs.objects) != 0 {
+		t.Fatalf("expected written objects to be removed, got %v", blobs.objects)
+	}
+	for id, status := range repo.Statuses {
+		if status != assets.AssetDeleted {
+			t.Fatalf("expected asset %s deleted, got %s", id, status)
+		}
+	}
+
+	storage, repo, _ = testStorage(t, 10)
+	metas, err := storage.SaveAssets(context.Background(), "files", []byte("a"), []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, meta := range metas {
+		if repo.Statuses[meta.Id] != assets.AssetCreated {
+			t.Fatalf("expected asset %s created, got %s", meta.Id, repo.Statuses[meta.Id])
+		}
+	}
+}
+
+func TestSaveAssetStreamCompensation(t *testing.T) {
+	storage, repo, blobs := testStorage(t, 0)
+
+	if _, err := storage.SaveAssetStream(context.Background(), "files", strings.NewReader("content"), 7); err == nil {
+		t.Fatal("expected saving error")
+	}
+	// asset is inserted before its object, so failed object is never left without asset
+	if len(repo.Metas) != 1 || len(blobs.objects) != 0 {
+		t.Fatalf("expected one asset and no objects, got %d assets, %v", len(repo.Metas), blobs.objects)
+	}
+	for id, status := range repo.Statuses {
+		if status != assets.AssetDeleted {
+			t.Fatalf("expected asset %s deleted, got %s", id, status)
+		}
+	}
+
+	storage, repo, _ = testStorage(t, 10)
+	meta, err := storage.SaveAssetStream(context.Background(), "files", strings.NewReader("content"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Statuses[meta.Id] != assets.AssetCreated || repo.Metas[meta.Id].Hash == "" {
+		t.Fatalf("expected created asset with hash, got %s", repo.Statuses[meta.Id])
+	}
+}
+
+func TestReapPendingAssets(t *testing.T) {
+	storage, repo, blobs := testStorage(t, 10)
+	ctx := context.Background()
+
+	metas, _ := storage.SaveAssets(ctx, "files", []byte("a"))
+	stale := assets.AssetMetadataDTO{Id: "stale", ResourceId: "stale-resource", Bucket: "files", CreatedAt: time.Now().Add(-2 * time.Hour)}
+	fresh := assets.AssetMetadataDTO{Id: "fresh", ResourceId: "fresh-resource", Bucket: "files", CreatedAt: time.Now()}
+	repo.SaveAssetsMetadata(ctx, stale, fresh)
+	blobs.SaveFile(ctx, "files", stale.ResourceId, nil, 0)
+
+	// multipart upload may still be written, while expired one is aborted with its tail
+	upload := assets.AssetMetadataDTO{Id: "upload", ResourceId: "upload-resource", Bucket: "files", UploadId: "u1", CreatedAt: time.Now().Add(-2 * time.Hour)}
+	expired := assets.AssetMetadataDTO{Id: "expired", ResourceId: "expired-resource", Bucket: "files", UploadId: "u2", CreatedAt: time.Now().Add(-assets.MultipartTTL - 2*time.Hour)}
+	repo.SaveAssetsMetadata(ctx, upload, expired)
+	blobs.objects["files/u2/parts"] = true
+	blobs.SaveFile(ctx, "files", assets.MultipartTailId(expired.ResourceId), nil, 0)
+
+	count, err := storage.ReapPendingAssets(ctx, time.Hour)
+	if err != nil || count != 2 {
+		t.Fatalf("expected two reaped assets, got %d, %v", count, err)
+	}
+	if blobs.objects["files/u2/parts"] || blobs.objects["files/expired-resource.tail"] {
+		t.Fatal("expected expired multipart upload aborted and its tail removed")
+	}
+	if repo.Statuses["stale"] != assets.AssetDeleted || blobs.objects["files/stale-resource"] {
+		t.Fatal("expected stale asset and its object removed")
+	}
+	if repo.Statuses["fresh"] != assets.AssetPending || repo.Statuses["upload"] != assets.AssetPending || repo.Statuses[metas[0].Id] != assets.AssetCreated {
+		t.Fatal("expected fresh, uploading and created assets untouched")
+	}
+
+	// saving reaped in the meantime isn't completed
+	if err := storage.CompletePending(ctx, assets.AssetCreated, "stale"); err != assets.ErrReaped {
+		t.Fatalf("expected reaped error, got %v", err)
+	}
+}
+
+func TestReapSkipsReferencedPendingAssets(t *testing.T) {
+	storage, repo, blobs := testStorage(t, 10)
+	ctx := context.Background()
+
+	// assets of batch saving were left pending by earlier releases, though images reference them
+	legacy := assets.AssetMetadataDTO{Id: "legacy", ResourceId: "legacy-resource", Bucket: "images", CreatedAt: time.Now().Add(-48 * time.Hour)}
+	repo.SaveAssetsMetadata(ctx, legacy)
+	repo.Refs[legacy.Id] = 1
+	blobs.SaveFile(ctx, "images", legacy.ResourceId, nil, 0)
+
+	count, err := storage.ReapPendingAssets(ctx, time.Hour)
+	if err != nil || count != 0 {
+		t.Fatalf("expected nothing reaped, got %d, %v", count, err)
+	}
+	if repo.Statuses[legacy.Id] != assets.AssetPending || !blobs.objects["images/legacy-resource"] {
+		t.Fatal("expected referenced pending asset and its object untouched")
+	}
+}
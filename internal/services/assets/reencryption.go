@@ -0,0 +1,61 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+)
+
+const reencryptBatchSize = 100
+
+// Re-encrypts data keys of assets with active master key after rotation, so previous
+// master key can be removed. Objects themselves are not rewritten, since data keys stay the
+// same, and assets stored before encryption was enabled are left as plaintext
+func (s *Storage) ReencryptAssets(ctx context.Context) (int, error) {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::ReencryptAssets")
+	defer span.End()
+
+	if s.keyring == nil {
+		return 0, fmt.Errorf("assets encryption is disabled")
+	}
+
+	count, after := 0, ""
+	for {
+		metas, err := s.metaRepo.GetAssetsToReencrypt(ctx, s.keyring.ActiveKeyId(), after, reencryptBatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("failed getting assets to re-encrypt")
+			return count, err
+		}
+		if len(metas) == 0 {
+			break
+		}
+
+		for _, meta := range metas {
+			dataKey, err := s.keyring.unwrapDataKey(meta.KeyId, meta.DataKey)
+			if err != nil {
+				log.Error().Err(err).Msgf("failed decrypting asset (id=%s) data key, key_id=%s", meta.Id, meta.KeyId)
+				return count, err
+			}
+			wrapped, err := s.keyring.wrapDataKey(dataKey)
+			if err != nil {
+				log.Error().Err(err).Msgf("failed encrypting asset (id=%s) data key", meta.Id)
+				return count, err
+			}
+
+			if err := s.metaRepo.UpdateAssetDataKey(ctx, meta.Id, s.keyring.ActiveKeyId(), wrapped); err != nil {
+				log.Error().Err(err).Msgf("failed updating asset (id=%s) data key", meta.Id)
+				return count, err
+			}
+			if err := s.metaCache.DeleteAssetMetadata(ctx, meta.Id); err != nil {
+				log.Warning().Err(err).Msg("failed deleting metadata from cache")
+			}
+			count++
+		}
+
+		after = metas[len(metas)-1].Id
+	}
+
+	log.Info().Msgf("re-encrypted %d assets data keys with master key %s", count, s.keyring.ActiveKeyId())
+	return count, nil
+}
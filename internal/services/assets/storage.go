@@ -1,34 +1,50 @@
 package assets
 
 import (
+	"bytes"
 	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"shorty/internal/common"
+	"shorty/internal/common/broker"
 	"shorty/internal/common/logging"
 	"strings"
+	"time"
 
-	"github.com/minio/minio-go/v7"
 	"go.opentelemetry.io/otel/trace"
 )
 
-func NewStorage(metaRepo MetadataRepo, metaCache MetadataCache, s3 *minio.Client, logger logging.Logger, tracer trace.Tracer) *Storage {
+// Content read from storage doesn't match hash computed on upload
+var ErrCorrupted = errors.New("asset is corrupted")
+
+// Assets are encrypted at rest when keyring is given, otherwise they are stored as plaintext
+func NewStorage(metaRepo MetadataRepo, metaCache MetadataCache, blobs BlobStore, broker broker.Broker, keyring *Keyring, logger logging.Logger, tracer trace.Tracer) *Storage {
 	return &Storage{
 		logger:    logger.WithService("assets"),
 		tracer:    tracer,
-		fileRepo:  newFileRepo(s3, tracer),
+		blobs:     blobs,
 		metaRepo:  metaRepo,
 		metaCache: metaCache,
+		broker:    broker,
+		keyring:   keyring,
 	}
 }
 
 type Storage struct {
 	logger    logging.Logger
 	tracer    trace.Tracer
-	fileRepo  *fileRepo
+	blobs     BlobStore
 	metaRepo  MetadataRepo
 	metaCache MetadataCache
+	broker    broker.Broker
+	keyring   *Keyring
 }
 
+// Saves assets in steps: metadata is inserted as pending, objects are written, then
+// assets are marked created. Failed step is compensated by removing written objects
 func (s *Storage) SaveAssets(ctx context.Context, bucket string, assets ...[]byte) ([]AssetMetadataDTO, error) {
 	log := s.logger.WithContext(ctx)
 
@@ -37,6 +53,8 @@ func (s *Storage) SaveAssets(ctx context.Context, bucket string, assets ...[]byt
 
 	ids := make([]string, len(assets))
 	metadatas := make([]AssetMetadataDTO, len(assets))
+	ciphers := make([]cipher.AEAD, len(assets))
+	createdAt := time.Now().UTC()
 	for i, asset := range assets {
 		ids[i] = common.NewShortId(32)
 		metadatas[i] = AssetMetadataDTO{
@@ -45,24 +63,37 @@ func (s *Storage) SaveAssets(ctx context.Context, bucket string, assets ...[]byt
 			Size:       len(asset),
 			Hash:       common.NewAssetHash(asset),
 			Bucket:     bucket,
+			CreatedAt:  createdAt,
+		}
+
+		var err error
+		ciphers[i], err = s.newAssetCipher(&metadatas[i])
+		if err != nil {
+			log.Error().Err(err).Msg("failed creating asset data key")
+			return nil, err
 		}
 	}
 
+	// assets are inserted as pending, so objects of crashed saving are reaped by janitor
 	if err := s.metaRepo.SaveAssetsMetadata(ctx, metadatas...); err != nil {
 		log.Error().Err(err).Msg("failed saving assets metadatas")
 		return nil, err
 	}
 
+	saved := make([]string, 0, len(assets))
 	for i, asset := range assets {
 		meta := metadatas[i]
-		if err := s.fileRepo.SaveFile(ctx, bucket, meta.ResourceId, asset); err != nil {
+		if err := s.saveObject(ctx, bucket, meta.ResourceId, ciphers[i], bytes.NewReader(asset), int64(len(asset))); err != nil {
 			log.Error().Err(err).Msgf("failed saving asset file, bucket=%s", bucket)
+			s.abortPending(ctx, bucket, ids, saved)
 			return nil, err
 		}
+		saved = append(saved, meta.ResourceId)
 	}
 
-	if err := s.metaRepo.SetAssetsStatus(ctx, "created", ids...); err != nil {
+	if err := s.completePending(ctx, AssetCreated, ids...); err != nil {
 		log.Error().Err(err).Msg("failed updating assets statuses")
+		s.abortPending(ctx, bucket, ids, saved)
 		return nil, err
 	}
 
@@ -85,6 +116,217 @@ func (s *Storage) SaveAssets(ctx context.Context, bucket string, assets ...[]byt
 	return metadatas, nil
 }
 
+// Streams asset of given size to storage, hash is calculated on the fly.
+// Metadata is saved after upload, since hash is unknown before it.
+func (s *Storage) SaveAssetStream(ctx context.Context, bucket string, r io.Reader, size int64) (*AssetMetadataDTO, error) {
+	ctx, span := s.tracer.Start(ctx, "assets::SaveAssetStream")
+	defer span.End()
+
+	return s.saveAssetStream(ctx, bucket, r, size, AssetCreated)
+}
+
+// Streams infected asset to storage with quarantined status
+func (s *Storage) QuarantineAssetStream(ctx context.Context, bucket string, r io.Reader, size int64) (*AssetMetadataDTO, error) {
+	ctx, span := s.tracer.Start(ctx, "assets::QuarantineAssetStream")
+	defer span.End()
+
+	return s.saveAssetStream(ctx, bucket, r, size, AssetQuarantined)
+}
+
+// Quarantines already stored asset, all its references stop being served
+func (s *Storage) QuarantineAsset(ctx context.Context, id string) error {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::QuarantineAsset")
+	defer span.End()
+
+	if err := s.metaRepo.SetAssetsStatus(ctx, AssetQuarantined, id); err != nil {
+		log.Error().Err(err).Msgf("failed quarantining asset (id=%s)", id)
+		return err
+	}
+	if err := s.metaCache.DeleteAssetMetadata(ctx, id); err != nil {
+		log.Warning().Err(err).Msg("failed deleting metadata from cache")
+	}
+
+	log.Warning().Msgf("quarantined asset, id=%s", id)
+	return nil
+}
+
+// Records that asset content was scanned for malware and is clean, so its duplicates
+// are reused without scanning
+func (s *Storage) MarkAssetScanned(ctx context.Context, id string) error {
+	ctx, span := s.tracer.Start(ctx, "assets::MarkAssetScanned")
+	defer span.End()
+
+	if err := s.metaRepo.SetAssetScanned(ctx, id); err != nil {
+		s.logger.WithContext(ctx).Error().Err(err).Msgf("failed marking asset (id=%s) scanned", id)
+		return err
+	}
+	return nil
+}
+
+// Asset is inserted as pending before its object is written, so object of crashed
+// saving is reaped by janitor. Hash is filled when stream is written
+func (s *Storage) saveAssetStream(ctx context.Context, bucket string, r io.Reader, size int64, status AssetStatus) (*AssetMetadataDTO, error) {
+	log := s.logger.WithContext(ctx)
+
+	meta := AssetMetadataDTO{
+		Id:         common.NewShortId(32),
+		ResourceId: common.NewShortId(32),
+		Size:       int(size),
+		Bucket:     bucket,
+		CreatedAt:  time.Now().UTC(),
+	}
+	aead, err := s.newAssetCipher(&meta)
+	if err != nil {
+		log.Error().Err(err).Msg("failed creating asset data key")
+		return nil, err
+	}
+
+	if err := s.metaRepo.SaveAssetsMetadata(ctx, meta); err != nil {
+		log.Error().Err(err).Msg("failed saving asset metadata")
+		return nil, err
+	}
+
+	hr := &hashingReader{r: io.LimitReader(r, size), hasher: common.NewAssetHasher()}
+	if err := s.saveObject(ctx, bucket, meta.ResourceId, aead, hr, size); err != nil {
+		log.Error().Err(err).Msgf("failed saving asset file, bucket=%s", bucket)
+		s.abortPending(ctx, bucket, []string{meta.Id}, []string{meta.ResourceId})
+		return nil, err
+	}
+	if hr.read != size {
+		log.Error().Msgf("asset stream size mismatch, expected=%d, read=%d", size, hr.read)
+		s.abortPending(ctx, bucket, []string{meta.Id}, []string{meta.ResourceId})
+		return nil, fmt.Errorf("asset stream size mismatch")
+	}
+	meta.Hash = common.AssetHasherSum(hr.hasher)
+
+	if err := s.completePendingAsset(ctx, meta, status); err != nil {
+		log.Error().Err(err).Msg("failed updating asset status")
+		s.abortPending(ctx, bucket, []string{meta.Id}, []string{meta.ResourceId})
+		return nil, err
+	}
+
+	log.Info().Msgf("saved asset stream, bucket=%s, id=%s, status=%s", bucket, meta.Id, status)
+
+	if status == AssetCreated {
+		if err := s.metaCache.PutAssetMetadata(ctx, meta); err != nil {
+			s.logger.Warning().Err(err).Msg("failed putting metadata to cache")
+		}
+	}
+
+	return &meta, nil
+}
+
+// Creates data key for new asset and returns its cipher,
+// cipher is nil when encryption is disabled
+func (s *Storage) newAssetCipher(meta *AssetMetadataDTO) (cipher.AEAD, error) {
+	if s.keyring == nil {
+		return nil, nil
+	}
+
+	dataKey, wrapped, err := s.keyring.newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	meta.KeyId, meta.DataKey = s.keyring.ActiveKeyId(), wrapped
+
+	return newAEAD(dataKey)
+}
+
+// Returns cipher of existing asset, nil means asset is stored as plaintext
+func (s *Storage) assetCipher(keyId string, wrapped []byte) (cipher.AEAD, error) {
+	if keyId == "" {
+		return nil, nil
+	}
+	if s.keyring == nil {
+		return nil, ErrUnknownKey
+	}
+
+	dataKey, err := s.keyring.unwrapDataKey(keyId, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(dataKey)
+}
+
+// Saves plaintext of given size as object, it is encrypted when cipher is given
+func (s *Storage) saveObject(ctx context.Context, bucket, resourceId string, aead cipher.AEAD, r io.Reader, size int64) error {
+	if aead != nil {
+		r, size = newEncryptingReader(r, aead, 0), encryptedSize(size)
+	}
+	return s.blobs.SaveFile(ctx, bucket, resourceId, r, size)
+}
+
+func (s *Storage) removeFile(ctx context.Context, bucket, resourceId string) {
+	if err := s.blobs.RemoveFile(ctx, bucket, resourceId); err != nil {
+		s.logger.WithContext(ctx).Error().Err(err).Msgf("failed removing asset file, bucket=%s, resource=%s", bucket, resourceId)
+	}
+}
+
+type hashingReader struct {
+	r      io.Reader
+	hasher hash.Hash
+	read   int64
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hasher.Write(p[:n])
+	h.read += int64(n)
+	return n, err
+}
+
+// Verifies hash of body read sequentially from start, once its size is read or it ends. Mismatch is
+// returned as ErrCorrupted instead of the last chunk, so streamed response is cut short. Seeking
+// elsewhere than start skips verification, e.g. for ranges, until body is read from start again
+type verifyingReader struct {
+	body      io.ReadSeekCloser
+	hash      string
+	size      int64
+	hasher    hash.Hash
+	offset    int64
+	hashed    int64
+	corrupted func()
+}
+
+func newVerifyingReader(body io.ReadSeekCloser, hash string, size int64, corrupted func()) *verifyingReader {
+	return &verifyingReader{body: body, hash: hash, size: size, hasher: common.NewAssetHasher(), corrupted: corrupted}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	if v.hashed == v.offset {
+		v.hasher.Write(p[:n])
+		v.hashed += int64(n)
+	}
+	v.offset += int64(n)
+
+	ended := v.hashed >= v.size || err == io.EOF
+	if v.hashed == v.offset && ended && common.AssetHasherSum(v.hasher) != v.hash {
+		v.corrupted()
+		return 0, ErrCorrupted
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := v.body.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	if pos == 0 {
+		v.hasher.Reset()
+		v.hashed = 0
+	}
+	v.offset = pos
+	return pos, nil
+}
+
+func (v *verifyingReader) Close() error {
+	return v.body.Close()
+}
+
 func (s *Storage) getAssetMetadata(ctx context.Context, id string) (*AssetMetadataDTO, error) {
 	if meta, err := s.metaCache.GetAssetMetadata(ctx, id); err == nil && meta != nil {
 		return meta, nil
@@ -104,10 +346,24 @@ func (s *Storage) getAssetMetadata(ctx context.Context, id string) (*AssetMetada
 	return meta, nil
 }
 
-func (s *Storage) GetAssetBytes(ctx context.Context, bucket, id string) ([]byte, error) {
+func (s *Storage) GetAssetMetadata(ctx context.Context, id string) (*AssetMetadataDTO, error) {
+	ctx, span := s.tracer.Start(ctx, "assets::GetAssetMetadata")
+	defer span.End()
+
+	meta, err := s.getAssetMetadata(ctx, id)
+	if err != nil {
+		s.logger.WithContext(ctx).Error().Err(err).Msgf("failed getting asset (id=%s) metadata", id)
+		return nil, err
+	}
+	return meta, nil
+}
+
+// Returns asset with lazily read body, body must be closed by caller. Body read whole
+// from start returns ErrCorrupted, when content doesn't match asset hash
+func (s *Storage) GetAsset(ctx context.Context, bucket, id string) (*AssetDTO, error) {
 	log := s.logger.WithContext(ctx)
 
-	ctx, span := s.tracer.Start(ctx, "assets::GetAssetBytes")
+	ctx, span := s.tracer.Start(ctx, "assets::GetAsset")
 	defer span.End()
 
 	meta, err := s.getAssetMetadata(ctx, id)
@@ -119,19 +375,67 @@ func (s *Storage) GetAssetBytes(ctx context.Context, bucket, id string) ([]byte,
 		return nil, fmt.Errorf("no such asset with id = %s", id)
 	}
 
-	fileBytes, err := s.fileRepo.GetFile(ctx, bucket, meta.ResourceId)
+	aead, err := s.assetCipher(meta.KeyId, meta.DataKey)
 	if err != nil {
-		log.Error().Err(err).Msgf("failed getting asset bytes, bucket=%s, id=%s", bucket, id)
+		log.Error().Err(err).Msgf("failed decrypting asset data key, id=%s, key_id=%s", id, meta.KeyId)
+		return nil, err
+	}
+
+	body, err := s.blobs.GetFile(ctx, bucket, meta.ResourceId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting asset, bucket=%s, id=%s", bucket, id)
+		return nil, err
+	}
+	if aead != nil {
+		body = newDecryptingReader(body, aead, int64(meta.Size))
+	}
+	body = newVerifyingReader(body, meta.Hash, int64(meta.Size), func() {
+		log.Error().Msgf("asset is corrupted, bucket=%s, id=%s, size=%d", bucket, id, meta.Size)
+	})
+
+	log.Info().Msgf("got asset, bucket=%s, id=%s", bucket, id)
+	return &AssetDTO{
+		Id:        meta.Id,
+		Size:      meta.Size,
+		Hash:      meta.Hash,
+		Bucket:    meta.Bucket,
+		CreatedAt: meta.CreatedAt,
+		Body:      body,
+	}, nil
+}
+
+// Reads whole asset, its hash is verified by body, so corrupted content is never returned
+func (s *Storage) GetAssetBytes(ctx context.Context, bucket, id string) ([]byte, error) {
+	log := s.logger.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "assets::GetAssetBytes")
+	defer span.End()
+
+	asset, err := s.GetAsset(ctx, bucket, id)
+	if err != nil {
+		return nil, err
+	}
+	defer asset.Body.Close()
+
+	fileBytes, err := io.ReadAll(asset.Body)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed reading asset bytes, bucket=%s, id=%s", bucket, id)
 		return nil, err
 	}
 
-	log.Info().Msgf("got asset bytes, bucket=%s, id=%s", bucket, id)
 	return fileBytes, nil
 }
 
-// func (s *Storage) GetAssetDuplicate(ctx context.Context, size int, hash string) (*AssetMetadataDTO, error) {
-// 	ctx, span := s.tracer.Start(ctx, "assets::GetAsset")
-// 	defer span.End()
+// Returns existing asset with same content in bucket, or nil if there is none
+func (s *Storage) GetAssetDuplicate(ctx context.Context, bucket string, size int, hash string) (*AssetMetadataDTO, error) {
+	ctx, span := s.tracer.Start(ctx, "assets::GetAssetDuplicate")
+	defer span.End()
+
+	meta, err := s.metaRepo.GetAssetDuplicate(ctx, bucket, size, hash)
+	if err != nil {
+		s.logger.WithContext(ctx).Error().Err(err).Msgf("failed getting asset duplicate, bucket=%s", bucket)
+		return nil, err
+	}
 
-// 	return nil, nil
-// }
+	return meta, nil
+}
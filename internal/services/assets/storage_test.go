@@ -0,0 +1,46 @@
+package assets
+
+import (
+	"bytes"
+	"io"
+	"shorty/internal/common"
+	"testing"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	content := bytes.Repeat([]byte("content"), 1000)
+	hash := common.NewAssetHash(content)
+	corrupted := 0
+	newReader := func(body []byte) *verifyingReader {
+		return newVerifyingReader(nopCloser{bytes.NewReader(body)}, hash, int64(len(content)), func() { corrupted++ })
+	}
+
+	// content type sniffing and size probing seek before body is read whole
+	r := newReader(content)
+	r.Read(make([]byte, 512))
+	r.Seek(0, io.SeekEnd)
+	r.Seek(0, io.SeekStart)
+	if read, err := io.ReadAll(io.LimitReader(r, int64(len(content)))); err != nil || !bytes.Equal(read, content) {
+		t.Fatalf("expected valid content read, got %d bytes (err=%v)", len(read), err)
+	}
+
+	// range isn't verified
+	r = newReader(append([]byte("x"), content[1:]...))
+	r.Seek(100, io.SeekStart)
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("expected range read without verification, got %v", err)
+	}
+
+	// last chunk of corrupted content is withheld
+	r = newReader(append([]byte("x"), content[1:]...))
+	read, err := io.ReadAll(r)
+	if err != ErrCorrupted || len(read) >= len(content) || corrupted != 1 {
+		t.Fatalf("expected corrupted error before content end, got %d bytes (err=%v)", len(read), err)
+	}
+
+	// truncated content is corrupted too
+	r = newReader(content[:len(content)-1])
+	if _, err := io.ReadAll(r); err != ErrCorrupted {
+		t.Fatalf("expected corrupted error for truncated content, got %v", err)
+	}
+}
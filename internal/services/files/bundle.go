@@ -0,0 +1,195 @@
+package files
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"shorty/internal/common"
+	"strings"
+)
+
+var ErrBundleFiles = errors.New("bundle must have from 1 to 20 files")
+
+const (
+	MaxBundleFiles = 20
+	MaxBundleSize  = 100 * 1024 * 1024
+)
+
+// Uploads files into bundle, all files share bundle options. Download limit
+// and browser encryption are supported only for standalone files
+func (s *Service) UploadBundle(ctx context.Context, bundleFiles []BundleFile, opts FileOptions) (*BundleMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "files::UploadBundle")
+	defer span.End()
+
+	if len(bundleFiles) == 0 || len(bundleFiles) > MaxBundleFiles {
+		return nil, ErrBundleFiles
+	}
+	if err := opts.Validate(); err != nil || opts.MaxDownloads > 0 || opts.Encrypted {
+		return nil, ErrOptions
+	}
+
+	total := int64(0)
+	for _, file := range bundleFiles {
+		total += file.Size
+	}
+	if total > MaxBundleSize {
+		return nil, ErrTooBig
+	}
+
+	// bundle is saved after its files, so it's never seen partially uploaded
+	fileIds := make([]string, 0, len(bundleFiles))
+	uploaded := make([]*FileMetadataDTO, 0, len(bundleFiles))
+	for _, file := range bundleFiles {
+		meta, err := s.uploadFile(ctx, file.Name, file.Body, file.Size, opts)
+		if err != nil {
+			log.Error().Err(err).Msgf("err uploading file %s into bundle", file.Name)
+			s.discardFiles(context.WithoutCancel(ctx), uploaded)
+			return nil, err
+		}
+		fileIds = append(fileIds, meta.Id)
+		uploaded = append(uploaded, meta)
+	}
+
+	bundle := &BundleMetadataDTO{
+		Id:        common.NewShortId(32),
+		ExpiresAt: common.NewExpiresAt(opts.Retention),
+	}
+	if err := s.metaRepo.SaveBundleMetadata(ctx, *bundle, fileIds); err != nil {
+		log.Error().Err(err).Msg("err saving bundle info")
+		s.discardFiles(context.WithoutCancel(ctx), uploaded)
+		return nil, ErrInternal
+	}
+
+	log.Info().Msgf("saved bundle with id=%s, files=%d", bundle.Id, len(bundleFiles))
+	return bundle, nil
+}
+
+// Expires files of failed bundle and releases their assets, files aren't reachable
+// without bundle, so they are deleted right away
+func (s *Service) discardFiles(ctx context.Context, files []*FileMetadataDTO) {
+	log := s.log.WithContext(ctx)
+
+	released := make([]string, 0, len(files))
+	for _, file := range files {
+		if err := s.metaRepo.ExpireFile(ctx, file.Id); err != nil {
+			log.Error().Err(err).Msgf("err expiring discarded file (id=%s)", file.Id)
+			continue
+		}
+		released = append(released, file.FileId)
+	}
+	if len(released) == 0 {
+		return
+	}
+	if err := s.assetStorage.ReleaseAssets(ctx, released...); err != nil {
+		log.Error().Err(err).Msgf("err releasing discarded files assets, ids=%v", released)
+	}
+}
+
+func (s *Service) GetBundle(ctx context.Context, id string) (*BundleDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "files::GetBundle")
+	defer span.End()
+
+	meta, err := s.metaRepo.GetBundleMetadata(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Msg("failed getting bundle info")
+		return nil, ErrInternal
+	}
+	if meta == nil || common.IsExpired(meta.ExpiresAt) {
+		log.Info().Msgf("not found bundle with id=%s", id)
+		return nil, ErrNotFound
+	}
+
+	bundleFiles, err := s.metaRepo.GetBundleFiles(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting bundle (id=%s) files", id)
+		return nil, ErrInternal
+	}
+	if len(bundleFiles) == 0 {
+		log.Info().Msgf("bundle with id=%s has no files", id)
+		return nil, ErrNotFound
+	}
+
+	return &BundleDTO{
+		Id:        meta.Id,
+		ExpiresAt: meta.ExpiresAt,
+		Files:     bundleFiles,
+	}, nil
+}
+
+// Streams ZIP archive of bundle files, assets are read one by one, so
+// archive is never buffered. Writer may be partially written on error
+func (s *Service) WriteBundleArchive(ctx context.Context, bundle *BundleDTO, w io.Writer) error {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "files::WriteBundleArchive")
+	defer span.End()
+
+	archive := zip.NewWriter(w)
+	names := map[string]int{}
+	for _, file := range bundle.Files {
+		asset, err := s.assetStorage.GetAsset(ctx, BucketName, file.FileId)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed getting bundle (id=%s) file (id=%s) from storage", bundle.Id, file.Id)
+			return ErrInternal
+		}
+
+		method := zip.Deflate
+		if isCompressed(file.MimeType) {
+			method = zip.Store
+		}
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     archiveEntryName(file.Name, names),
+			Method:   method,
+			Modified: asset.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, asset.Body)
+		}
+		asset.Body.Close()
+		if err != nil {
+			log.Error().Err(err).Msgf("failed writing bundle (id=%s) file (id=%s) to archive", bundle.Id, file.Id)
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Error().Err(err).Msgf("failed finishing bundle (id=%s) archive", bundle.Id)
+		return err
+	}
+
+	log.Info().Msgf("streamed bundle archive, id=%s", bundle.Id)
+	s.downloadsCounter.Inc()
+	return nil
+}
+
+// Entry name is sanitized so archive can't write outside of extraction dir,
+// same names get numeric suffix
+func archiveEntryName(name string, names map[string]int) string {
+	name = common.SanitizeFileName(name)
+
+	count := names[name]
+	names[name]++
+	if count == 0 {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), count, ext)
+}
+
+// Already compressed content is stored as is, deflating it only wastes CPU
+func isCompressed(mimeType string) bool {
+	for _, prefix := range []string{"image/", "audio/", "video/", "application/zip", "application/x-gzip", "application/x-rar-compressed", "application/x-7z-compressed"} {
+		if strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+	return false
+}
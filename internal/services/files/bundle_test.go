@@ -0,0 +1,13 @@
+package files
+
+import "testing"
+
+func TestArchiveEntryName(t *testing.T) {
+	names := map[string]int{}
+	expected := []string{"a.txt", "a (1).txt", "passwd", "a (2).txt"}
+	for i, name := range []string{"a.txt", "a.txt", "../../etc/passwd", "dir/a.txt"} {
+		if entry := archiveEntryName(name, names); entry != expected[i] {
+			t.Fatalf("%q: expected %q, got %q", name, expected[i], entry)
+		}
+	}
+}
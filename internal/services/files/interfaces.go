@@ -5,6 +5,12 @@ import (
 )
 
 type MetadataRepo interface {
-	SaveFileMetadata(ctx context.Context, meta FileMetadataDTO) error
+	SaveFileMetadata(ctx context.Context, meta FileMetadataDTO) (bool, error)
 	GetFileMetadata(ctx context.Context, id string) (*FileMetadataExDTO, error)
+	IncFileDownloads(ctx context.Context, id string) (bool, error)
+	ExpireFile(ctx context.Context, id string) error
+	SetFilePreview(ctx context.Context, id, previewId string) (bool, error)
+	SaveBundleMetadata(ctx context.Context, meta BundleMetadataDTO, fileIds []string) error
+	GetBundleMetadata(ctx context.Context, id string) (*BundleMetadataDTO, error)
+	GetBundleFiles(ctx context.Context, bundleId string) ([]FileMetadataExDTO, error)
 }
@@ -1,15 +1,104 @@
 package files
 
+import (
+	"io"
+	"time"
+)
+
 type FileMetadataDTO struct {
-	Id     string
-	FileId string
-	Name   string
+	Id           string
+	FileId       string
+	Name         string
+	ExpiresAt    *time.Time
+	MaxDownloads int // zero means unlimited
+	Encrypted    bool
+	MimeType     string
 }
 
 type FileMetadataExDTO struct {
-	Id     string
-	FileId string
-	Name   string
-	Size   int
-	Hash   string
+	Id           string
+	FileId       string
+	Name         string
+	Size         int
+	Hash         string
+	ExpiresAt    *time.Time
+	MaxDownloads int
+	Downloads    int
+	Encrypted    bool
+	MimeType     string
+
+	PreviewId     string // stored preview, empty until preview worker creates it
+	PreviewFailed bool   // preview can't be created from file content
+}
+
+// Limited file, which is deleted after max downloads count
+func (f *FileMetadataExDTO) Limited() bool {
+	return f.MaxDownloads > 0
+}
+
+func (f *FileMetadataExDTO) RemainingDownloads() int {
+	return max(f.MaxDownloads-f.Downloads, 0)
+}
+
+// Size of file content, ciphertext overhead isn't counted for encrypted files
+func (f *FileMetadataExDTO) PlainSize() int {
+	if f.Encrypted {
+		return max(f.Size-EncryptionOverhead, 0)
+	}
+	return f.Size
+}
+
+type FileOptions struct {
+	Retention    time.Duration // zero keeps file forever
+	MaxDownloads int           // zero means unlimited
+	Encrypted    bool          // content is encrypted in browser, server stores only ciphertext
+}
+
+type BundleMetadataDTO struct {
+	Id        string
+	ExpiresAt *time.Time
+}
+
+// Bundle of files uploaded together, files are ordered by upload
+type BundleDTO struct {
+	Id        string
+	ExpiresAt *time.Time
+	Files     []FileMetadataExDTO
+}
+
+func (b *BundleDTO) Size() int {
+	size := 0
+	for _, file := range b.Files {
+		size += file.PlainSize()
+	}
+	return size
+}
+
+// File of bundle upload with stream of given size
+type BundleFile struct {
+	Name string
+	Body io.ReadSeeker
+	Size int64
+}
+
+// Preview of file content shown on view page, only fields of its kind are set
+type FilePreview struct {
+	Kind        PreviewKind
+	Text        string // head of text file
+	Language    string
+	Highlighted string // text rendered by highlighter
+	Entries     []ArchiveEntry
+	Pdf         *PdfInfo
+	Truncated   bool // text or entries list is cut by preview limits
+}
+
+type ArchiveEntry struct {
+	Name string
+	Size int64
+	Dir  bool
+}
+
+type PdfInfo struct {
+	Version string
+	Pages   int // zero when page count is unknown
 }
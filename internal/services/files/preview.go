@@ -0,0 +1,440 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"regexp"
+	"shorty/internal/common/highlight"
+	"shorty/internal/services/assets"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type PreviewKind string
+
+const (
+	PreviewNone    PreviewKind = ""
+	PreviewText    PreviewKind = "text"
+	PreviewImage   PreviewKind = "image"
+	PreviewPdf     PreviewKind = "pdf"
+	PreviewArchive PreviewKind = "archive"
+)
+
+const (
+	PreviewTextMaxLen   = 16 * 1024
+	PreviewMaxEntries   = 100
+	PreviewImageMaxSize = 20 * 1024 * 1024
+
+	previewsBatchSize = 10
+	previewsBlockTime = 5 * time.Second
+
+	// PDF is scanned for metadata only within its head and tail
+	pdfScanLen = 1024 * 1024
+	// Limit of decompressed tar.gz stream read while listing entries
+	tarGzipMaxLen = 100 * 1024 * 1024
+)
+
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"application/toml":       true,
+	"application/yaml":       true,
+}
+
+// Kind of preview shown on file view page. Encrypted files can't be previewed by
+// server, limited files aren't previewed, so their content is only given by download
+func (f *FileMetadataExDTO) PreviewKind() PreviewKind {
+	if f.Encrypted || f.Limited() {
+		return PreviewNone
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(f.MimeType)
+	name := strings.ToLower(f.Name)
+	switch {
+	case strings.HasPrefix(mediaType, "text/") || textMimeTypes[mediaType]:
+		return PreviewText
+	case mediaType == "image/jpeg" || mediaType == "image/png" || mediaType == "image/gif":
+		return PreviewImage
+	case mediaType == "application/pdf":
+		return PreviewPdf
+	case mediaType == "application/zip" || mediaType == "application/x-tar":
+		return PreviewArchive
+	case (mediaType == "application/x-gzip" || mediaType == "application/gzip") &&
+		(strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")):
+		return PreviewArchive
+	}
+	return PreviewNone
+}
+
+// Renders image preview of file, implemented by image service
+type PreviewRenderer interface {
+	CreatePreview(ctx context.Context, r io.Reader) ([]byte, error)
+}
+
+// Returns stored preview of file content. Image preview has no content, it is
+// served separately as preview asset. Missing preview is requested again, since
+// its request could be lost, and file is shown without preview until it is created
+func (s *Service) GetFilePreview(ctx context.Context, meta *FileMetadataExDTO) (*FilePreview, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "files::GetFilePreview")
+	defer span.End()
+
+	kind := meta.PreviewKind()
+	if kind == PreviewNone || meta.PreviewFailed {
+		return &FilePreview{Kind: PreviewNone}, nil
+	}
+	if meta.PreviewId == "" {
+		s.requestPreview(ctx, meta.Id)
+		return &FilePreview{Kind: PreviewNone}, nil
+	}
+	if kind == PreviewImage {
+		return &FilePreview{Kind: PreviewImage}, nil
+	}
+
+	previewBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, meta.PreviewId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting file (id=%s) preview", meta.Id)
+		return nil, ErrInternal
+	}
+
+	preview := &FilePreview{}
+	if err := json.Unmarshal(previewBytes, preview); err != nil {
+		log.Error().Err(err).Msgf("failed decoding file (id=%s) preview", meta.Id)
+		return nil, ErrInternal
+	}
+	return preview, nil
+}
+
+// Opens stored image preview of file, opening isn't counted as download
+func (s *Service) GetPreviewAsset(ctx context.Context, meta *FileMetadataExDTO) (*assets.AssetDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "files::GetPreviewAsset")
+	defer span.End()
+
+	if meta.PreviewKind() != PreviewImage || meta.PreviewId == "" {
+		return nil, ErrNotFound
+	}
+
+	asset, err := s.assetStorage.GetAsset(ctx, BucketName, meta.PreviewId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting file (id=%s) preview", meta.Id)
+		return nil, ErrInternal
+	}
+	return asset, nil
+}
+
+// Failed request only delays preview, it is requested again on file view
+func (s *Service) requestPreview(ctx context.Context, id string) {
+	if err := s.broker.PutPreviewsToCreate(ctx, id); err != nil {
+		s.log.WithContext(ctx).Warning().Err(err).Msgf("failed requesting file (id=%s) preview", id)
+	}
+}
+
+// Creates preview of file content and stores it as asset: image preview is a thumbnail,
+// others are encoded as JSON. Preview is created once, content which can't be
+// previewed is marked, so it isn't read again
+func (s *Service) CreatePreview(ctx context.Context, id string) error {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "files::CreatePreview")
+	defer span.End()
+
+	meta, err := s.metaRepo.GetFileMetadata(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting file (id=%s) info", id)
+		return ErrInternal
+	}
+	if meta == nil {
+		return ErrNotFound
+	}
+	if meta.PreviewKind() == PreviewNone || meta.PreviewId != "" || meta.PreviewFailed {
+		log.Info().Msgf("file (id=%s) preview already created", id)
+		return nil
+	}
+
+	asset, err := s.assetStorage.GetAsset(ctx, BucketName, meta.FileId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting file (id=%s) for preview", id)
+		return ErrInternal
+	}
+	defer asset.Body.Close()
+
+	previewBytes, err := s.renderPreview(ctx, meta, asset)
+	if err == ErrInternal {
+		return err
+	}
+	if err != nil {
+		// broken files are still downloadable, they just have no preview
+		log.Info().Err(err).Msgf("failed creating preview of file (id=%s)", id)
+		if _, err := s.metaRepo.SetFilePreview(ctx, id, ""); err != nil {
+			log.Error().Err(err).Msgf("failed marking file (id=%s) preview failed", id)
+			return ErrInternal
+		}
+		return nil
+	}
+
+	saved, err := s.assetStorage.SaveAssets(ctx, BucketName, previewBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("failed saving preview asset")
+		return ErrInternal
+	}
+
+	set, err := s.metaRepo.SetFilePreview(ctx, id, saved[0].Id)
+	if err != nil || !set {
+		// preview isn't referenced by file, it is created by another worker or file is deleted
+		if err := s.assetStorage.ReleaseAssets(ctx, saved[0].Id); err != nil {
+			log.Error().Err(err).Msgf("failed releasing preview asset (id=%s)", saved[0].Id)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed setting file (id=%s) preview", id)
+		return ErrInternal
+	}
+
+	log.Info().Msgf("created preview of file (id=%s, previewId=%s)", id, saved[0].Id)
+	return nil
+}
+
+// Internal error means preview should be retried, other errors mean content can't be previewed
+func (s *Service) renderPreview(ctx context.Context, meta *FileMetadataExDTO, asset *assets.AssetDTO) ([]byte, error) {
+	preview := &FilePreview{Kind: meta.PreviewKind()}
+
+	var err error
+	switch preview.Kind {
+	case PreviewImage:
+		// image is read before rendering, so renderer fails only on its content
+		imgBytes, err := io.ReadAll(io.LimitReader(asset.Body, PreviewImageMaxSize+1))
+		if err != nil {
+			s.log.WithContext(ctx).Error().Err(err).Msgf("failed reading file (id=%s) for preview", meta.Id)
+			return nil, ErrInternal
+		}
+		if len(imgBytes) > PreviewImageMaxSize {
+			return nil, ErrTooBig
+		}
+		return s.previewRenderer.CreatePreview(ctx, bytes.NewReader(imgBytes))
+	case PreviewText:
+		err = readTextPreview(preview, meta.Name, asset.Body)
+		if err == nil {
+			preview.Highlighted, err = highlight.Highlight(preview.Text, preview.Language)
+		}
+	case PreviewPdf:
+		preview.Pdf, err = readPdfInfo(asset.Body, int64(asset.Size))
+	case PreviewArchive:
+		err = readArchivePreview(preview, meta.MimeType, asset.Body, int64(asset.Size))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(preview)
+}
+
+// Reads files from previews queue until context is done
+func (s *Service) RunPreviewWorker(ctx context.Context, consumer string) {
+	s.log.Info().Msgf("started file previews worker %s", consumer)
+
+	for ctx.Err() == nil {
+		messages, err := s.broker.GetPreviewsToCreate(ctx, consumer, previewsBatchSize, previewsBlockTime)
+		if err != nil {
+			s.log.Error().Err(err).Msg("failed reading file previews queue")
+			select {
+			case <-ctx.Done():
+			case <-time.After(previewsBlockTime):
+			}
+			continue
+		}
+
+		for _, msg := range messages {
+			err := s.CreatePreview(ctx, msg.Value)
+			if err == ErrInternal {
+				// not acked, will be claimed again later
+				continue
+			}
+			if err != nil {
+				s.log.Warning().Err(err).Msgf("dropped file (id=%s) from previews queue", msg.Value)
+			}
+
+			if err := s.broker.AckPreviewsToCreate(ctx, msg.Id); err != nil {
+				s.log.Error().Err(err).Msgf("failed acking file (id=%s) preview", msg.Value)
+			}
+		}
+	}
+
+	s.log.Info().Msgf("stopped file previews worker %s", consumer)
+}
+
+func readTextPreview(preview *FilePreview, name string, r io.Reader) error {
+	head, err := io.ReadAll(io.LimitReader(r, PreviewTextMaxLen+1))
+	if err != nil {
+		return err
+	}
+	if len(head) > PreviewTextMaxLen {
+		head, preview.Truncated = head[:PreviewTextMaxLen], true
+		// drop rune split by limit, other invalid bytes are replaced below
+		for n := 1; n < utf8.UTFMax && n <= len(head); n++ {
+			if utf8.RuneStart(head[len(head)-n]) {
+				if !utf8.FullRune(head[len(head)-n:]) {
+					head = head[:len(head)-n]
+				}
+				break
+			}
+		}
+	}
+
+	preview.Text = string(bytes.ToValidUTF8(head, []byte("�")))
+	preview.Language = highlight.LanguageByFileName(name)
+	if preview.Language == "" {
+		preview.Language = highlight.DetectLanguage(preview.Text)
+	}
+	return nil
+}
+
+var (
+	pdfVersionRegexp = regexp.MustCompile(`^%PDF-(\d\.\d)`)
+	pdfPagesRegexp   = regexp.MustCompile(`<<[^<>]*/Type\s*/Pages\b[^<>]*>>`)
+	pdfCountRegexp   = regexp.MustCompile(`/Count\s+(\d+)`)
+	pdfPageRegexp    = regexp.MustCompile(`/Type\s*/Page\b`)
+)
+
+// Reads PDF version and page count without parsing document. Page count is taken
+// from page tree root, which is usually near the file start or end. It is zero
+// when page tree is stored in compressed object stream
+func readPdfInfo(r io.ReadSeeker, size int64) (*PdfInfo, error) {
+	content, err := readHeadTail(r, size, pdfScanLen)
+	if err != nil {
+		return nil, err
+	}
+
+	version := pdfVersionRegexp.FindSubmatch(content)
+	if version == nil {
+		return nil, errors.New("missing PDF header")
+	}
+	info := &PdfInfo{Version: string(version[1])}
+
+	for _, pages := range pdfPagesRegexp.FindAll(content, -1) {
+		if count := pdfCountRegexp.FindSubmatch(pages); count != nil {
+			n, _ := strconv.Atoi(string(count[1]))
+			info.Pages = max(info.Pages, n)
+		}
+	}
+	if info.Pages == 0 && size <= 2*pdfScanLen {
+		info.Pages = len(pdfPageRegexp.FindAll(content, -1))
+	}
+
+	return info, nil
+}
+
+// Reads whole stream when it fits into two limits, otherwise only limit bytes of its head and tail
+func readHeadTail(r io.ReadSeeker, size int64, limit int64) ([]byte, error) {
+	if size <= 2*limit {
+		return io.ReadAll(r)
+	}
+
+	content := make([]byte, 2*limit)
+	if _, err := io.ReadFull(r, content[:limit]); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(size-limit, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, content[limit:]); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func readArchivePreview(preview *FilePreview, mimeType string, r io.ReadSeeker, size int64) error {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	switch mediaType {
+	case "application/zip":
+		return readZipEntries(preview, &readerAt{r: r}, size)
+	case "application/x-tar":
+		return readTarEntries(preview, r)
+	default:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return readTarEntries(preview, io.LimitReader(gz, tarGzipMaxLen))
+	}
+}
+
+// Zip entries are listed from central directory at the end of archive, so content isn't read
+func readZipEntries(preview *FilePreview, r io.ReaderAt, size int64) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range archive.File {
+		if len(preview.Entries) == PreviewMaxEntries {
+			preview.Truncated = true
+			break
+		}
+		preview.Entries = append(preview.Entries, ArchiveEntry{
+			Name: file.Name,
+			Size: int64(file.UncompressedSize64),
+			Dir:  file.FileInfo().IsDir(),
+		})
+	}
+	return nil
+}
+
+// Tar has no index, so entries are read one by one, until limit is reached
+func readTarEntries(preview *FilePreview, r io.Reader) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// entries listed before truncated stream are still shown
+			if len(preview.Entries) > 0 {
+				preview.Truncated = true
+				return nil
+			}
+			return err
+		}
+
+		if len(preview.Entries) == PreviewMaxEntries {
+			preview.Truncated = true
+			return nil
+		}
+		preview.Entries = append(preview.Entries, ArchiveEntry{
+			Name: header.Name,
+			Size: header.Size,
+			Dir:  header.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+// Adapts seekable asset body for archive/zip, reads aren't concurrent
+type readerAt struct {
+	r io.ReadSeeker
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
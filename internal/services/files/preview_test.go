@@ -0,0 +1,137 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"shorty/internal/services/assets/assetstest"
+	"strings"
+	"testing"
+)
+
+func TestPreviewKind(t *testing.T) {
+	cases := map[string]PreviewKind{
+		"text/plain; charset=utf-8": PreviewText,
+		"application/json":          PreviewText,
+		"image/png":                 PreviewImage,
+		"application/pdf":           PreviewPdf,
+		"application/zip":           PreviewArchive,
+		"application/octet-stream":  PreviewNone,
+	}
+	for mimeType, expected := range cases {
+		meta := &FileMetadataExDTO{Name: "file", MimeType: mimeType}
+		if kind := meta.PreviewKind(); kind != expected {
+			t.Fatalf("%s: expected %q, got %q", mimeType, expected, kind)
+		}
+	}
+
+	encrypted := &FileMetadataExDTO{MimeType: "text/plain", Encrypted: true}
+	limited := &FileMetadataExDTO{MimeType: "text/plain", MaxDownloads: 1}
+	if encrypted.PreviewKind() != PreviewNone || limited.PreviewKind() != PreviewNone {
+		t.Fatalf("expected no preview for encrypted and limited files")
+	}
+}
+
+func TestReadTextPreview(t *testing.T) {
+	// multibyte rune is split by limit
+	content := strings.Repeat("a", PreviewTextMaxLen-1) + "ж"
+	preview := &FilePreview{}
+	if err := readTextPreview(preview, "notes.txt", strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if !preview.Truncated || len(preview.Text) != PreviewTextMaxLen-1 {
+		t.Fatalf("expected text truncated before split rune, got %d bytes", len(preview.Text))
+	}
+}
+
+func TestReadPdfInfo(t *testing.T) {
+	pdf := "%PDF-1.7\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+		"2 0 obj\n<< /Kids [3 0 R 4 0 R] /Count 2 /Type /Pages >>\nendobj\n" +
+		"3 0 obj\n<< /Type /Page /Parent 2 0 R >>\nendobj\n%%EOF"
+	info, err := readPdfInfo(strings.NewReader(pdf), int64(len(pdf)))
+	if err != nil || info.Version != "1.7" || info.Pages != 2 {
+		t.Fatalf("expected PDF 1.7 with 2 pages, got %+v (err=%v)", info, err)
+	}
+
+	if _, err := readPdfInfo(strings.NewReader("not pdf"), 7); err == nil {
+		t.Fatalf("expected error for missing header")
+	}
+}
+
+func TestReadArchivePreview(t *testing.T) {
+	zipBuff := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(zipBuff)
+	for i := range PreviewMaxEntries + 1 {
+		w, _ := zipWriter.Create(fmt.Sprintf("dir/%d.txt", i))
+		w.Write([]byte("content"))
+	}
+	zipWriter.Close()
+
+	preview := &FilePreview{}
+	err := readArchivePreview(preview, "application/zip", bytes.NewReader(zipBuff.Bytes()), int64(zipBuff.Len()))
+	if err != nil || len(preview.Entries) != PreviewMaxEntries || !preview.Truncated {
+		t.Fatalf("expected truncated zip listing, got %d entries (err=%v)", len(preview.Entries), err)
+	}
+
+	tarBuff := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(tarBuff)
+	tarWriter.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755})
+	tarWriter.WriteHeader(&tar.Header{Name: "dir/a.txt", Size: 7, Mode: 0644})
+	tarWriter.Write([]byte("content"))
+	tarWriter.Close()
+
+	preview = &FilePreview{}
+	err = readArchivePreview(preview, "application/x-tar", bytes.NewReader(tarBuff.Bytes()), int64(tarBuff.Len()))
+	if err != nil || len(preview.Entries) != 2 || !preview.Entries[0].Dir || preview.Entries[1].Size != 7 {
+		t.Fatalf("unexpected tar listing %+v (err=%v)", preview.Entries, err)
+	}
+}
+
+func TestCreatePreview(t *testing.T) {
+	ctx := context.Background()
+	repo := assetstest.NewRepo()
+	files, broker := &memoryFiles{}, &assetstest.Broker{}
+	service := newTestService(t, repo, files, broker)
+
+	text := "package main\n"
+	file, err := service.UploadFile(ctx, "main.go", strings.NewReader(text), int64(len(text)), FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broker.Previews) != 1 || broker.Previews[0] != file.Id {
+		t.Fatalf("expected preview requested on upload, got %v", broker.Previews)
+	}
+
+	// preview is created once, second request is skipped
+	for range 2 {
+		if err := service.CreatePreview(ctx, file.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta, _ := files.GetFileMetadata(ctx, file.Id)
+	preview, err := service.GetFilePreview(ctx, meta)
+	if err != nil || preview.Kind != PreviewText || !strings.Contains(preview.Highlighted, "main") {
+		t.Fatalf("expected stored text preview, got %+v (err=%v)", preview, err)
+	}
+	if len(broker.Released) != 0 {
+		t.Fatalf("expected no released previews, got %v", broker.Released)
+	}
+
+	broken := "GIF87a broken image"
+	file, err = service.UploadFile(ctx, "photo.gif", strings.NewReader(broken), int64(len(broken)), FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.CreatePreview(ctx, file.Id); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ = files.GetFileMetadata(ctx, file.Id)
+	if preview, err := service.GetFilePreview(ctx, meta); err != nil || preview.Kind != PreviewNone || !meta.PreviewFailed {
+		t.Fatalf("expected failed image preview, got %+v (err=%v)", preview, err)
+	}
+	if len(broker.Previews) != 2 {
+		t.Fatalf("expected failed preview not requested again, got %v", broker.Previews)
+	}
+}
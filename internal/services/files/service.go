@@ -3,10 +3,12 @@ package files
 import (
 	"context"
 	"errors"
+	"io"
 	"shorty/internal/common"
 	"shorty/internal/common/broker"
 	"shorty/internal/common/logging"
 	"shorty/internal/common/metrics"
+	"shorty/internal/common/scanner"
 	"shorty/internal/services/assets"
 
 	"go.opentelemetry.io/otel/trace"
@@ -16,21 +18,36 @@ var (
 	ErrInternal = errors.New("internal error")
 	ErrNotFound = errors.New("file not found")
 	ErrTooBig   = errors.New("file too big")
+	ErrOptions  = errors.New("invalid file options")
+	ErrInfected = errors.New("file contains malware")
+	ErrScan     = errors.New("file can't be scanned for malware, try again later")
+
+	// Asset is deleted before file referencing it is saved, it happens when duplicate is deleted
+	ErrAssetDeleted = errors.New("file asset is deleted")
 )
 
 const (
-	BucketName = "files"
-	MaxSize    = 20 * 1024 * 1024
+	BucketName       = "files"
+	MaxSize          = 20 * 1024 * 1024
+	MaxDownloadLimit = 1000
+
+	// Encrypted file is prefixed with 12 bytes AES-GCM nonce and followed by 16 bytes tag
+	EncryptionOverhead = 12 + 16
 )
 
-func NewService(metaRepo MetadataRepo, assetsStorage *assets.Storage, log logging.Logger, tracer trace.Tracer, meter metrics.Meter) *Service {
+func NewService(metaRepo MetadataRepo, assetsStorage *assets.Storage, broker broker.Broker, malwareScanner *scanner.Checker, previewRenderer PreviewRenderer, log logging.Logger, tracer trace.Tracer, meter metrics.Meter) *Service {
 	return &Service{
-		log:              log.WithService("files"),
-		tracer:           tracer,
-		assetStorage:     assetsStorage,
-		metaRepo:         metaRepo,
-		uploadsCounter:   meter.NewCounter("files_uploads", "Count of file uploads"),
-		downloadsCounter: meter.NewCounter("files_downloads", "Count of file downloads"),
+		log:               log.WithService("files"),
+		tracer:            tracer,
+		broker:            broker,
+		assetStorage:      assetsStorage,
+		metaRepo:          metaRepo,
+		malwareScanner:    malwareScanner,
+		previewRenderer:   previewRenderer,
+		uploadsCounter:    meter.NewCounter("files_uploads", "Count of file uploads"),
+		downloadsCounter:  meter.NewCounter("files_downloads", "Count of file downloads"),
+		duplicatesCounter: meter.NewCounter("files_duplicates", "Count of file uploads with existing asset"),
+		infectedCounter:   meter.NewCounter("files_infected", "Count of file uploads with detected malware"),
 	}
 }
 
@@ -41,39 +58,239 @@ type Service struct {
 	assetStorage *assets.Storage
 	metaRepo     MetadataRepo
 
-	uploadsCounter   metrics.Counter
-	downloadsCounter metrics.Counter
+	malwareScanner  *scanner.Checker
+	previewRenderer PreviewRenderer
+
+	uploadsCounter    metrics.Counter
+	downloadsCounter  metrics.Counter
+	duplicatesCounter metrics.Counter
+	infectedCounter   metrics.Counter
+}
+
+// Streams file of given size to assets storage. Stream is read twice: first
+// to find existing asset with same hash, then to save it if there is none
+func (s *Service) UploadFile(ctx context.Context, name string, r io.ReadSeeker, size int64, opts FileOptions) (*FileMetadataDTO, error) {
+	ctx, span := s.tracer.Start(ctx, "files::UploadFile")
+	defer span.End()
+
+	return s.uploadFile(ctx, name, r, size, opts)
 }
 
-func (s *Service) UploadFile(ctx context.Context, name string, fileBytes []byte) (*FileMetadataDTO, error) {
+// Duplicate deleted after it is found is a deduplication miss, so file is uploaded
+// again, deleted asset isn't found as duplicate
+func (s *Service) uploadFile(ctx context.Context, name string, r io.ReadSeeker, size int64, opts FileOptions) (*FileMetadataDTO, error) {
 	log := s.log.WithContext(ctx)
 
-	ctx, span := s.tracer.Start(ctx, "files::UploadFile")
+	file, err := s.storeFile(ctx, name, r, size, opts)
+	if err != ErrAssetDeleted {
+		return file, err
+	}
+
+	log.Info().Msgf("duplicate of file %s is deleted, uploading it again", name)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		log.Error().Err(err).Msg("err rewinding file stream")
+		return nil, ErrInternal
+	}
+	file, err = s.storeFile(ctx, name, r, size, opts)
+	if err == ErrAssetDeleted {
+		return nil, ErrInternal
+	}
+	return file, err
+}
+
+func (s *Service) storeFile(ctx context.Context, name string, r io.ReadSeeker, size int64, opts FileOptions) (*FileMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkSize(size, opts); err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, min(size, common.MimeSniffLen))
+	if _, err := io.ReadFull(r, head); err != nil {
+		log.Error().Err(err).Msg("err reading file stream head")
+		return nil, ErrInternal
+	}
+	mimeType := detectMimeType(name, head, opts)
+
+	hasher := common.NewAssetHasher()
+	hasher.Write(head)
+	if _, err := io.Copy(hasher, io.LimitReader(r, size-int64(len(head)))); err != nil {
+		log.Error().Err(err).Msg("err hashing file stream")
+		return nil, ErrInternal
+	}
+
+	duplicate, err := s.assetStorage.GetAssetDuplicate(ctx, BucketName, int(size), common.AssetHasherSum(hasher))
+	if err != nil {
+		return nil, ErrInternal
+	}
+	if duplicate != nil && duplicate.Scanned {
+		log.Info().Msgf("found existing file asset with same hash (id=%s), add reference to it", duplicate.Id)
+		s.duplicatesCounter.Inc()
+		return s.createFile(ctx, name, duplicate, mimeType, opts)
+	}
+
+	// unscanned duplicate has same content as stream, so it is checked by stream scan
+	result, err := s.malwareScanner.CheckStream(ctx, r, size)
+	if errors.Is(err, scanner.ErrTooBig) {
+		return nil, ErrTooBig
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("err scanning file stream")
+		return nil, ErrScan
+	}
+	if result.Infected {
+		log.Warning().Msgf("detected malware %s in file %s", result.Signature, name)
+		s.infectedCounter.Inc()
+		if duplicate != nil {
+			if err := s.assetStorage.QuarantineAsset(ctx, duplicate.Id); err != nil {
+				log.Error().Err(err).Msg("err quarantining infected file duplicate")
+			}
+		} else if _, err := s.assetStorage.QuarantineAssetStream(ctx, BucketName, r, size); err != nil {
+			log.Error().Err(err).Msg("err saving infected file to quarantine")
+		}
+		return nil, ErrInfected
+	}
+	if !result.Scanned {
+		log.Warning().Msgf("file %s is saved without malware scan", name)
+	}
+
+	asset := duplicate
+	if asset != nil {
+		log.Info().Msgf("found existing unscanned file asset with same hash (id=%s), add reference to it", asset.Id)
+		s.duplicatesCounter.Inc()
+	} else if asset, err = s.assetStorage.SaveAssetStream(ctx, BucketName, r, size); err != nil {
+		log.Error().Err(err).Msg("err saving file asset")
+		return nil, ErrInternal
+	}
+	s.markScanned(ctx, asset, result)
+
+	return s.createFile(ctx, name, asset, mimeType, opts)
+}
+
+// Failing to mark asset only makes its duplicates scanned again
+func (s *Service) markScanned(ctx context.Context, asset *assets.AssetMetadataDTO, result *scanner.Result) {
+	if !result.Scanned || asset.Scanned {
+		return
+	}
+	if err := s.assetStorage.MarkAssetScanned(ctx, asset.Id); err == nil {
+		asset.Scanned = true
+	}
+}
+
+// Ciphertext of encrypted file is never sniffed, it looks like random bytes anyway
+func detectMimeType(name string, head []byte, opts FileOptions) string {
+	if opts.Encrypted {
+		return common.DefaultMimeType
+	}
+	return common.DetectMimeType(name, head)
+}
+
+func (o FileOptions) Validate() error {
+	if o.Retention < 0 || o.MaxDownloads < 0 || o.MaxDownloads > MaxDownloadLimit {
+		return ErrOptions
+	}
+	return nil
+}
+
+// Encrypted files may exceed max size by ciphertext overhead,
+// but can't be smaller than it
+func checkSize(size int64, opts FileOptions) error {
+	maxSize := int64(MaxSize)
+	if opts.Encrypted {
+		if size < EncryptionOverhead {
+			return ErrOptions
+		}
+		maxSize += EncryptionOverhead
+	}
+	if size > maxSize {
+		return ErrTooBig
+	}
+	return nil
+}
+
+// Creates file record for already stored asset, asset is scanned from storage
+// and MIME type is detected by its head
+func (s *Service) CreateFile(ctx context.Context, name string, asset *assets.AssetMetadataDTO, opts FileOptions) (*FileMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "files::CreateFile")
 	defer span.End()
 
-	if len(fileBytes) > MaxSize {
+	stored, err := s.assetStorage.GetAsset(ctx, BucketName, asset.Id)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting file asset (id=%s)", asset.Id)
+		return nil, ErrInternal
+	}
+	defer stored.Body.Close()
+
+	result, err := s.malwareScanner.CheckStream(ctx, stored.Body, int64(stored.Size))
+	if errors.Is(err, scanner.ErrTooBig) {
 		return nil, ErrTooBig
 	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed scanning file asset (id=%s)", asset.Id)
+		return nil, ErrScan
+	}
+	if result.Infected {
+		log.Warning().Msgf("detected malware %s in file asset (id=%s)", result.Signature, asset.Id)
+		s.infectedCounter.Inc()
+		if err := s.assetStorage.QuarantineAsset(ctx, asset.Id); err != nil {
+			return nil, ErrInternal
+		}
+		return nil, ErrInfected
+	}
+	s.markScanned(ctx, asset, result)
 
-	result, err := s.assetStorage.SaveAssets(ctx, BucketName, fileBytes)
+	head, err := io.ReadAll(io.LimitReader(stored.Body, common.MimeSniffLen))
 	if err != nil {
-		log.Error().Err(err).Msg("err saving file asset")
+		log.Error().Err(err).Msgf("failed reading file asset (id=%s) head", asset.Id)
 		return nil, ErrInternal
 	}
 
+	return s.createFile(ctx, name, asset, detectMimeType(name, head, opts), opts)
+}
+
+func (s *Service) createFile(ctx context.Context, name string, asset *assets.AssetMetadataDTO, mimeType string, opts FileOptions) (*FileMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Encrypted && asset.Size < EncryptionOverhead {
+		log.Info().Msgf("encrypted file asset (id=%s) is smaller than ciphertext overhead", asset.Id)
+		return nil, ErrOptions
+	}
+
 	metadata := &FileMetadataDTO{
-		Id:     common.NewShortId(32),
-		FileId: result[0].Id,
-		Name:   name,
+		Id:           common.NewShortId(32),
+		FileId:       asset.Id,
+		Name:         name,
+		ExpiresAt:    common.NewExpiresAt(opts.Retention),
+		MaxDownloads: opts.MaxDownloads,
+		Encrypted:    opts.Encrypted,
+		MimeType:     mimeType,
 	}
-	if err := s.metaRepo.SaveFileMetadata(ctx, *metadata); err != nil {
+	saved, err := s.metaRepo.SaveFileMetadata(ctx, *metadata)
+	if err != nil {
 		log.Error().Err(err).Msg("err saving file info")
 		return nil, ErrInternal
 	}
+	if !saved {
+		log.Warning().Msgf("file asset (id=%s) is deleted before file is saved", asset.Id)
+		return nil, ErrAssetDeleted
+	}
 
 	log.Info().Msgf("saved file with id=%s", metadata.Id)
 	s.uploadsCounter.Inc()
 
+	file := FileMetadataExDTO{Name: name, MaxDownloads: opts.MaxDownloads, Encrypted: opts.Encrypted, MimeType: mimeType}
+	if file.PreviewKind() != PreviewNone {
+		s.requestPreview(ctx, metadata.Id)
+	}
+
 	return metadata, nil
 }
 
@@ -92,31 +309,94 @@ func (s *Service) GetFileMetadata(ctx context.Context, id string) (*FileMetadata
 		log.Info().Msgf("not found file with id=%s", id)
 		return nil, ErrNotFound
 	}
+	if common.IsExpired(meta.ExpiresAt) {
+		log.Info().Msgf("file with id=%s is expired", id)
+		return nil, ErrNotFound
+	}
+	if meta.Limited() && meta.RemainingDownloads() == 0 {
+		log.Info().Msgf("file with id=%s reached downloads limit", id)
+		return nil, ErrNotFound
+	}
 
 	log.Info().Msgf("read file metadata, id=%s", meta.Id)
 
 	return meta, nil
 }
 
-func (s *Service) GetFileBytes(ctx context.Context, id string) ([]byte, error) {
+// Opens asset of file got by GetFileMetadata and counts download of limited file,
+// asset body must be closed by caller
+func (s *Service) GetFile(ctx context.Context, meta *FileMetadataExDTO) (*assets.AssetDTO, error) {
 	log := s.log.WithContext(ctx)
 
-	ctx, span := s.tracer.Start(ctx, "files::GetFileBytes")
+	ctx, span := s.tracer.Start(ctx, "files::GetFile")
 	defer span.End()
 
-	meta, err := s.GetFileMetadata(ctx, id)
+	asset, err := s.assetStorage.GetAsset(ctx, BucketName, meta.FileId)
 	if err != nil {
-		return nil, err
+		log.Error().Err(err).Msgf("failed getting file (id=%s, file_id=%s) from storage", meta.Id, meta.FileId)
+		return nil, ErrInternal
 	}
 
-	assetBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, meta.FileId)
-	if err != nil {
-		log.Error().Err(err).Msgf("failed getting file (id=%s, file_id=%s) from storage", id, meta.FileId)
-		return nil, ErrInternal
+	if meta.Limited() {
+		// counted atomically after file is opened, so concurrent downloads can't exceed
+		// the limit and failed opening isn't counted
+		counted, err := s.metaRepo.IncFileDownloads(ctx, meta.Id)
+		if err != nil || !counted {
+			asset.Body.Close()
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("failed counting file (id=%s) download", meta.Id)
+			return nil, ErrInternal
+		}
+		if !counted {
+			log.Info().Msgf("file with id=%s reached downloads limit", meta.Id)
+			return nil, ErrNotFound
+		}
+		meta.Downloads++
+
+		if meta.RemainingDownloads() == 0 {
+			asset.Body = &closeHookBody{ReadSeekCloser: asset.Body, hook: func() {
+				s.deleteDownloadedFile(context.WithoutCancel(ctx), meta)
+			}}
+		}
 	}
 
-	log.Info().Msgf("read file bytes, id=%s", meta.Id)
+	log.Info().Msgf("opened file, id=%s", meta.Id)
 	s.downloadsCounter.Inc()
 
-	return assetBytes, nil
+	return asset, nil
+}
+
+// Asset body running hook after it's closed
+type closeHookBody struct {
+	io.ReadSeekCloser
+	hook func()
+}
+
+func (b *closeHookBody) Close() error {
+	err := b.ReadSeekCloser.Close()
+	b.hook()
+	return err
+}
+
+// Expires file after its last allowed download is streamed and releases its assets,
+// so they are deleted right away instead of waiting for expiration sweeper
+func (s *Service) deleteDownloadedFile(ctx context.Context, meta *FileMetadataExDTO) {
+	log := s.log.WithContext(ctx)
+
+	if err := s.metaRepo.ExpireFile(ctx, meta.Id); err != nil {
+		log.Error().Err(err).Msgf("failed expiring file (id=%s) after last download", meta.Id)
+		return
+	}
+
+	released := []string{meta.FileId}
+	if meta.PreviewId != "" {
+		released = append(released, meta.PreviewId)
+	}
+	if err := s.assetStorage.ReleaseAssets(ctx, released...); err != nil {
+		log.Error().Err(err).Msgf("failed releasing file (id=%s) assets after last download", meta.Id)
+		return
+	}
+
+	log.Info().Msgf("deleted file with id=%s after last download", meta.Id)
 }
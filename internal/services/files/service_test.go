@@ -0,0 +1,251 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"shorty/internal/common"
+	"shorty/internal/common/logging"
+	"shorty/internal/common/metrics"
+	"shorty/internal/common/scanner"
+	"shorty/internal/databases/blob"
+	"shorty/internal/services/assets"
+	"shorty/internal/services/assets/assetstest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type memoryFiles struct {
+	MetadataRepo
+	files     []FileMetadataDTO
+	previews  map[string]string
+	downloads map[string]int
+	bundles   map[string][]string
+	assets    *assetstest.Repo
+	// Asset deleted right before file is saved, as if it is released concurrently
+	deleteOnSave string
+}
+
+func (r *memoryFiles) SaveFileMetadata(ctx context.Context, meta FileMetadataDTO) (bool, error) {
+	if r.deleteOnSave != "" {
+		r.assets.Statuses[r.deleteOnSave], r.deleteOnSave = assets.AssetDeleted, ""
+	}
+	if r.assets.Statuses[meta.FileId] != assets.AssetCreated {
+		return false, nil
+	}
+	r.files = append(r.files, meta)
+	return true, nil
+}
+
+func (r *memoryFiles) GetFileMetadata(ctx context.Context, id string) (*FileMetadataExDTO, error) {
+	for _, file := range r.files {
+		if file.Id == id {
+			asset := r.assets.Metas[file.FileId]
+			previewId, ok := r.previews[id]
+			return &FileMetadataExDTO{
+				Id: id, FileId: file.FileId, Name: file.Name, Size: asset.Size, Hash: asset.Hash, MimeType: file.MimeType,
+				ExpiresAt: file.ExpiresAt, MaxDownloads: file.MaxDownloads, Downloads: r.downloads[id],
+				PreviewId: previewId, PreviewFailed: ok && previewId == "",
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryFiles) IncFileDownloads(ctx context.Context, id string) (bool, error) {
+	for _, file := range r.files {
+		if file.Id == id && file.MaxDownloads > 0 && r.downloads[id] < file.MaxDownloads {
+			r.downloads[id]++
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryFiles) ExpireFile(ctx context.Context, id string) error {
+	for i := range r.files {
+		if r.files[i].Id == id {
+			expiresAt := time.Now().UTC()
+			r.files[i].ExpiresAt = &expiresAt
+		}
+	}
+	return nil
+}
+
+func (r *memoryFiles) SaveBundleMetadata(ctx context.Context, meta BundleMetadataDTO, fileIds []string) error {
+	r.bundles[meta.Id] = fileIds
+	return nil
+}
+
+func (r *memoryFiles) SetFilePreview(ctx context.Context, id, previewId string) (bool, error) {
+	if _, ok := r.previews[id]; ok {
+		return false, nil
+	}
+	r.previews[id] = previewId
+	return true, nil
+}
+
+// Renders image preview by copying it, image is broken unless it is GIF89a
+type copyRenderer struct{}
+
+func (copyRenderer) CreatePreview(ctx context.Context, r io.Reader) ([]byte, error) {
+	imgBytes, _ := io.ReadAll(r)
+	if !bytes.HasPrefix(imgBytes, []byte("GIF89a")) {
+		return nil, errors.New("invalid image")
+	}
+	return imgBytes, nil
+}
+
+func newTestService(t *testing.T, repo *assetstest.Repo, files *memoryFiles, broker *assetstest.Broker) *Service {
+	t.Helper()
+	files.assets, files.previews, files.downloads, files.bundles = repo, map[string]string{}, map[string]int{}, map[string][]string{}
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := noop.NewTracerProvider().Tracer("test")
+
+	storage := assets.NewStorage(repo, assetstest.Cache{}, blob.NewMemory(), broker, nil, logger, tracer)
+	checker := scanner.NewChecker(&scanner.Fake{}, scanner.FailClosed, scanner.DefaultMaxSize)
+	return NewService(files, storage, broker, checker, copyRenderer{}, logger, tracer, metrics.NewNoop())
+}
+
+// Asset saved while scanning was disabled must not be reused without scan
+func TestUploadScansUnscannedDuplicate(t *testing.T) {
+	ctx := context.Background()
+	clean, infected := "clean content", scanner.EicarSignature
+	repo := assetstest.NewRepo()
+	repo.Metas["clean"] = assets.AssetMetadataDTO{Id: "clean", Bucket: BucketName, Size: len(clean), Hash: common.NewAssetHash([]byte(clean))}
+	repo.Metas["infected"] = assets.AssetMetadataDTO{Id: "infected", Bucket: BucketName, Size: len(infected), Hash: common.NewAssetHash([]byte(infected))}
+	repo.Statuses["clean"], repo.Statuses["infected"] = assets.AssetCreated, assets.AssetCreated
+	files := &memoryFiles{}
+	service := newTestService(t, repo, files, &assetstest.Broker{})
+
+	file, err := service.UploadFile(ctx, "notes.txt", strings.NewReader(clean), int64(len(clean)), FileOptions{})
+	if err != nil || file.FileId != "clean" {
+		t.Fatalf("expected file referencing duplicate, got %+v (err=%v)", file, err)
+	}
+	if !repo.Metas["clean"].Scanned {
+		t.Fatal("expected duplicate to be marked scanned")
+	}
+
+	if _, err := service.UploadFile(ctx, "eicar.txt", strings.NewReader(infected), int64(len(infected)), FileOptions{}); err != ErrInfected {
+		t.Fatalf("expected infected error, got %v", err)
+	}
+	if repo.Statuses["infected"] != assets.AssetQuarantined || len(files.files) != 1 {
+		t.Fatal("expected infected duplicate to be quarantined without new file")
+	}
+}
+
+// Duplicate deleted before file referencing it is saved is a deduplication miss
+func TestUploadRetriesDeletedDuplicate(t *testing.T) {
+	ctx := context.Background()
+	content := "shared content"
+	repo := assetstest.NewRepo()
+	repo.Metas["shared"] = assets.AssetMetadataDTO{Id: "shared", Bucket: BucketName, Size: len(content), Hash: common.NewAssetHash([]byte(content)), Scanned: true}
+	repo.Statuses["shared"] = assets.AssetCreated
+	files := &memoryFiles{deleteOnSave: "shared"}
+	service := newTestService(t, repo, files, &assetstest.Broker{})
+
+	file, err := service.UploadFile(ctx, "notes.txt", strings.NewReader(content), int64(len(content)), FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileId == "shared" || repo.Statuses[file.FileId] != assets.AssetCreated {
+		t.Fatalf("expected file referencing new asset, got %+v", file)
+	}
+	if len(files.files) != 1 {
+		t.Fatalf("expected one saved file, got %d", len(files.files))
+	}
+}
+
+// Failed opening isn't counted as download, file is deleted after the last download is streamed
+func TestGetFileDeletesAfterLastDownload(t *testing.T) {
+	ctx := context.Background()
+	content := "limited content"
+	repo := assetstest.NewRepo()
+	files, broker := &memoryFiles{}, &assetstest.Broker{}
+	service := newTestService(t, repo, files, broker)
+
+	file, err := service.UploadFile(ctx, "notes.txt", strings.NewReader(content), int64(len(content)), FileOptions{MaxDownloads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := service.GetFileMetadata(ctx, file.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := repo.Metas[file.FileId]
+	delete(repo.Metas, file.FileId)
+	if _, err := service.GetFile(ctx, meta); err != ErrInternal {
+		t.Fatalf("expected internal error for missing asset, got %v", err)
+	}
+	if files.downloads[file.Id] != 0 {
+		t.Fatal("expected failed opening not counted")
+	}
+	repo.Metas[file.FileId] = missing
+
+	asset, err := service.GetFile(ctx, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(asset.Body); string(body) != content {
+		t.Fatalf("unexpected content %q", body)
+	}
+	if len(broker.Released) != 0 {
+		t.Fatal("expected asset released only after download is streamed")
+	}
+	asset.Body.Close()
+
+	if _, err := service.GetFileMetadata(ctx, file.Id); err != ErrNotFound {
+		t.Fatalf("expected file not found after last download, got %v", err)
+	}
+	if _, err := service.GetFile(ctx, meta); err != ErrNotFound {
+		t.Fatalf("expected download over limit not counted, got %v", err)
+	}
+	if files.files[0].ExpiresAt == nil || len(broker.Released) != 1 || broker.Released[0] != file.FileId {
+		t.Fatalf("expected file expired and asset released, got %v", broker.Released)
+	}
+}
+
+// Bundle is saved after its files, files uploaded before failure are deleted
+func TestUploadBundleDiscardsFilesOnFailure(t *testing.T) {
+	ctx := context.Background()
+	clean, infected := "clean content", scanner.EicarSignature
+	repo := assetstest.NewRepo()
+	files, broker := &memoryFiles{}, &assetstest.Broker{}
+	service := newTestService(t, repo, files, broker)
+
+	_, err := service.UploadBundle(ctx, []BundleFile{
+		{Name: "notes.txt", Body: strings.NewReader(clean), Size: int64(len(clean))},
+		{Name: "eicar.txt", Body: strings.NewReader(infected), Size: int64(len(infected))},
+	}, FileOptions{})
+	if err != ErrInfected {
+		t.Fatalf("expected infected error, got %v", err)
+	}
+	if len(files.bundles) != 0 {
+		t.Fatal("expected bundle not saved")
+	}
+	if len(files.files) != 1 || files.files[0].ExpiresAt == nil {
+		t.Fatal("expected uploaded file expired")
+	}
+	if len(broker.Released) != 1 || broker.Released[0] != files.files[0].FileId {
+		t.Fatalf("expected uploaded file asset released, got %v", broker.Released)
+	}
+
+	bundle, err := service.UploadBundle(ctx, []BundleFile{
+		{Name: "notes.txt", Body: strings.NewReader(clean), Size: int64(len(clean))},
+	}, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files.bundles[bundle.Id]) != 1 || files.bundles[bundle.Id][0] != files.files[1].Id {
+		t.Fatalf("expected bundle saved with its file, got %v", files.bundles)
+	}
+}
@@ -0,0 +1,55 @@
+package image
+
+const (
+	DefaultMaxWidth  = 8192
+	DefaultMaxHeight = 8192
+	DefaultMaxPixels = 40_000_000
+
+	DefaultThumbnailWidth   = 200
+	DefaultThumbnailQuality = 75
+
+	DefaultWatermarkPosition = WatermarkBottomRight
+	DefaultWatermarkOpacity  = 0.5
+	DefaultWatermarkScale    = 0.25
+)
+
+type Config struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+
+	ThumbnailWidth   int
+	ThumbnailQuality int // jpeg quality from 1 to 100
+
+	Watermark WatermarkConfig
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxWidth:  DefaultMaxWidth,
+		MaxHeight: DefaultMaxHeight,
+		MaxPixels: DefaultMaxPixels,
+
+		ThumbnailWidth:   DefaultThumbnailWidth,
+		ThumbnailQuality: DefaultThumbnailQuality,
+
+		Watermark: WatermarkConfig{
+			Position: DefaultWatermarkPosition,
+			Opacity:  DefaultWatermarkOpacity,
+			Scale:    DefaultWatermarkScale,
+		},
+	}
+}
+
+func (c Config) checkDimensions(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return ErrImageEmpty
+	}
+	if width > c.MaxWidth || height > c.MaxHeight {
+		return ErrImageDimensions
+	}
+	if int64(width)*int64(height) > int64(c.MaxPixels) {
+		return ErrImageDimensions
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package image
+
+import "testing"
+
+func TestCheckDimensions(t *testing.T) {
+	config := Config{MaxWidth: 100, MaxHeight: 50, MaxPixels: 4000}
+
+	cases := []struct {
+		width, height int
+		err           error
+	}{
+		{80, 40, nil},
+		{0, 40, ErrImageEmpty},
+		{80, 0, ErrImageEmpty},
+		{101, 10, ErrImageDimensions},
+		{10, 51, ErrImageDimensions},
+		{100, 50, ErrImageDimensions},
+	}
+
+	for _, c := range cases {
+		if err := config.checkDimensions(c.width, c.height); err != c.err {
+			t.Fatalf("%dx%d: expected %v, got %v", c.width, c.height, c.err, err)
+		}
+	}
+}
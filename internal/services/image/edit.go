@@ -0,0 +1,127 @@
+package image
+
+import (
+	"context"
+	"image"
+	"shorty/internal/common"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+type EditOptions struct {
+	Crop   *image.Rectangle // in source image coordinates, applied first
+	Rotate int              // clockwise, multiple of 90 degrees
+	FlipH  bool
+	FlipV  bool
+	Raw    bool // edit original without watermark, caller must check access to it
+}
+
+func applyEdit(img image.Image, opts EditOptions) (image.Image, error) {
+	if opts.Rotate%90 != 0 {
+		return nil, ErrInvalidEdit
+	}
+
+	if opts.Crop != nil {
+		crop := opts.Crop.Add(img.Bounds().Min)
+		if crop.Empty() || !crop.In(img.Bounds()) {
+			return nil, ErrInvalidEdit
+		}
+		img = transform.Crop(img, crop)
+	}
+
+	if rotate := (opts.Rotate%360 + 360) % 360; rotate != 0 {
+		img = transform.Rotate(img, float64(rotate), &transform.RotationOptions{ResizeBounds: true})
+	}
+	if opts.FlipH {
+		img = transform.FlipH(img)
+	}
+	if opts.FlipV {
+		img = transform.FlipV(img)
+	}
+
+	return img, nil
+}
+
+// Creates new image from edited original of source image, new image keeps link to the source.
+// Watermarked image is edited with its watermark unless raw original is requested, so
+// edit never exposes original without watermark
+func (s *Service) EditImage(ctx context.Context, id string, opts EditOptions) (*ImageMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::EditImage")
+	defer span.End()
+
+	source, err := s.GetImageMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	assetId, watermark := source.OriginalId, source.Watermark
+	if source.Watermark && !opts.Raw {
+		if source.WatermarkedId == "" {
+			return nil, ErrImageProcessing
+		}
+		assetId, watermark = source.WatermarkedId, false
+	}
+
+	origBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, assetId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting image original (id=%s, assetId=%s)", id, assetId)
+		return nil, ErrInternal
+	}
+
+	img, err := s.decodeImage(ctx, origBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	edited, err := applyEdit(img, opts)
+	if err != nil {
+		log.Info().Msgf("rejected invalid edit of image (id=%s)", id)
+		return nil, err
+	}
+
+	bounds := edited.Bounds()
+	if err := s.config.checkDimensions(bounds.Dx(), bounds.Dy()); err != nil {
+		return nil, err
+	}
+
+	editedBytes, err := encodeJpeg(edited, WatermarkedQuality)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encoding edited image")
+		return nil, ErrInternal
+	}
+
+	assets, err := s.assetStorage.SaveAssets(ctx, BucketName, editedBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("failed saving edited image asset")
+		return nil, ErrInternal
+	}
+
+	metadata := ImageMetadataDTO{
+		Id:         common.NewShortId(32),
+		Name:       source.Name,
+		OriginalId: assets[0].Id,
+		SourceId:   source.Id,
+		Watermark:  watermark,
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		Format:     FormatJpeg,
+		Status:     ImageProcessing,
+		ExpiresAt:  source.ExpiresAt,
+	}
+	if saved, err := s.metaRepo.SaveImageMetadata(ctx, metadata); err != nil || !saved {
+		log.Error().Err(err).Msg("failed saving edited image metadata")
+		return nil, ErrInternal
+	}
+
+	// image is saved already, it is put to queue again by stale images sweeper
+	if err := s.broker.PutImagesToProcess(ctx, metadata.Id); err != nil {
+		log.Error().Err(err).Msg("failed putting image to processing queue")
+	}
+
+	log.Info().Msgf("created edited image with id=%s from id=%s", metadata.Id, source.Id)
+	s.editsCounter.Inc()
+
+	return &metadata, nil
+}
@@ -0,0 +1,40 @@
+package image
+
+import (
+	"image"
+	"testing"
+)
+
+func TestApplyEdit(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	crop := image.Rect(0, 0, 30, 10)
+	outside := image.Rect(10, 10, 50, 20)
+
+	cases := []struct {
+		opts   EditOptions
+		width  int
+		height int
+		err    error
+	}{
+		{EditOptions{}, 40, 20, nil},
+		{EditOptions{Rotate: 90}, 20, 40, nil},
+		{EditOptions{Rotate: -90, FlipH: true}, 20, 40, nil},
+		{EditOptions{Rotate: 180, FlipV: true}, 40, 20, nil},
+		{EditOptions{Crop: &crop, Rotate: 270}, 10, 30, nil},
+		{EditOptions{Crop: &outside}, 0, 0, ErrInvalidEdit},
+		{EditOptions{Rotate: 45}, 0, 0, ErrInvalidEdit},
+	}
+
+	for i, c := range cases {
+		result, err := applyEdit(img, c.opts)
+		if err != c.err {
+			t.Fatalf("case %d: expected error %v, got %v", i, c.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if b := result.Bounds(); b.Dx() != c.width || b.Dy() != c.height {
+			t.Fatalf("case %d: expected %dx%d, got %dx%d", i, c.width, c.height, b.Dx(), b.Dy())
+		}
+	}
+}
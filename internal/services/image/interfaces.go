@@ -1,9 +1,20 @@
 package image
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type MetadataRepo interface {
-	SaveImageMetadata(ctx context.Context, meta ImageMetadataDTO) error
+	SaveImageMetadata(ctx context.Context, meta ImageMetadataDTO) (bool, error)
 	GetImageMetadataById(ctx context.Context, id string) (*ImageMetadataExDTO, error)
-	GetImageMetadataDuplicate(ctx context.Context, size int, hash string) (*ImageMetadataExDTO, error)
+	GetImageMetadataDuplicate(ctx context.Context, size int, hash string, watermark bool) (*ImageMetadataExDTO, error)
+	SetImageProcessed(ctx context.Context, id, thumbnailId, watermarkedId, placeholder string) (bool, error)
+	SetImageFailed(ctx context.Context, id string) error
+	ClaimStaleImages(ctx context.Context, olderThan time.Duration, limit int) ([]string, error)
+	SaveImageVariant(ctx context.Context, variant ImageVariantDTO) (string, error)
+	GetImageVariant(ctx context.Context, sourceId string, format Format) (*ImageVariantDTO, error)
+	CountThumbnails(ctx context.Context, createdBefore time.Time) (int, error)
+	GetThumbnailsBatch(ctx context.Context, after string, createdBefore time.Time, limit int) ([]ThumbnailSourceDTO, error)
+	SwapThumbnail(ctx context.Context, oldId, newId string) ([]string, error)
 }
@@ -1,10 +1,21 @@
 package image
 
+import "time"
+
 type ImageMetadataDTO struct {
-	Id          string
-	Name        string
-	OriginalId  string
-	ThumbnailId string
+	Id            string
+	Name          string
+	OriginalId    string
+	ThumbnailId   string
+	SourceId      string // image this one was edited from
+	Watermark     bool
+	WatermarkedId string // original with watermark, served instead of original when set
+	Width         int    // zero with height for images uploaded before dimensions were stored
+	Height        int
+	Format        Format
+	Placeholder   string
+	Status        ImageStatus
+	ExpiresAt     *time.Time
 }
 
 type ImageMetadataExDTO struct {
@@ -14,6 +25,47 @@ type ImageMetadataExDTO struct {
 	Hash                string
 	OriginalId          string
 	OriginalResourceId  string
+	OriginalScanned     bool // set only for duplicate lookup
 	ThumbnailId         string
 	ThumbnailResourceId string
+	SourceId            string
+	Watermark           bool
+	WatermarkedId       string
+	Width               int
+	Height              int
+	Format              Format
+	Placeholder         string
+	Status              ImageStatus
+	ExpiresAt           *time.Time
+}
+
+type ImageVariantDTO struct {
+	SourceId string
+	Format   Format
+	AssetId  string
+}
+
+// Thumbnail shared by images with the same source asset
+type ThumbnailSourceDTO struct {
+	ThumbnailId string
+	SourceId    string
+}
+
+type ImageStatus string
+
+const (
+	ImageProcessing ImageStatus = "processing"
+	ImageReady      ImageStatus = "ready"
+	ImageFailed     ImageStatus = "failed" // image can't be processed, it isn't shown
+)
+
+type Format string
+
+const (
+	FormatJpeg Format = "jpeg"
+	FormatWebp Format = "webp"
+)
+
+func (f Format) ContentType() string {
+	return "image/" + string(f)
 }
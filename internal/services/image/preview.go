@@ -0,0 +1,46 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/gif"
+	_ "image/png"
+	"io"
+)
+
+// Max size of image, which is rendered as preview of uploaded file
+const PreviewMaxSize = 20 * 1024 * 1024
+
+// Renders thumbnail of image, which isn't uploaded as image, for example file preview.
+// Unlike uploads, any decodable format is accepted, dimensions are limited by config
+func (s *Service) CreatePreview(ctx context.Context, r io.Reader) ([]byte, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::CreatePreview")
+	defer span.End()
+
+	imgBytes, err := io.ReadAll(io.LimitReader(r, PreviewMaxSize+1))
+	if err != nil {
+		log.Error().Err(err).Msg("failed reading preview source")
+		return nil, ErrInternal
+	}
+	if len(imgBytes) > PreviewMaxSize {
+		return nil, ErrImageTooLarge
+	}
+
+	imgInfo, _, err := image.DecodeConfig(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	if err := s.config.checkDimensions(imgInfo.Width, imgInfo.Height); err != nil {
+		log.Info().Msgf("skipped preview of image with dimensions %dx%d", imgInfo.Width, imgInfo.Height)
+		return nil, err
+	}
+
+	img, err := s.decodeImage(ctx, imgBytes)
+	if err != nil {
+		return nil, err
+	}
+	return s.createThumbnail(ctx, img)
+}
@@ -0,0 +1,217 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+const (
+	processingBatchSize = 10
+	processingBlockTime = 5 * time.Second
+
+	// Image processing longer is considered lost from queue and is put to it again
+	ProcessingStaleAfter = 15 * time.Minute
+)
+
+// Creates thumbnail, placeholder and variants for uploaded image
+func (s *Service) ProcessImage(ctx context.Context, id string) error {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::ProcessImage")
+	defer span.End()
+
+	meta, err := s.GetImageMetadata(ctx, id)
+	if err != nil {
+		return err
+	}
+	if meta.Status == ImageReady || meta.Status == ImageFailed {
+		log.Info().Msgf("image (id=%s) already processed with status %s", id, meta.Status)
+		return nil
+	}
+
+	origBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, meta.OriginalId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting image original (id=%s, assetId=%s)", id, meta.OriginalId)
+		return ErrInternal
+	}
+
+	if _, _, err := s.checkImage(ctx, bytes.NewReader(origBytes)); err != nil {
+		return err
+	}
+
+	img, err := s.decodeImage(ctx, origBytes)
+	if err != nil {
+		return err
+	}
+
+	publicId, publicBytes := meta.OriginalId, origBytes
+	watermarkedId := ""
+	if meta.Watermark {
+		img = s.config.Watermark.apply(img)
+
+		publicBytes, err = encodeJpeg(img, WatermarkedQuality)
+		if err != nil {
+			log.Error().Err(err).Msg("failed encoding watermarked image")
+			return ErrInternal
+		}
+
+		assets, err := s.assetStorage.SaveAssets(ctx, BucketName, publicBytes)
+		if err != nil {
+			log.Error().Err(err).Msg("failed saving watermarked asset")
+			return ErrInternal
+		}
+		watermarkedId = assets[0].Id
+		publicId = watermarkedId
+	}
+
+	thumbBytes, err := s.createThumbnail(ctx, img)
+	if err != nil {
+		return err
+	}
+
+	placeholder, err := s.createPlaceholder(ctx, img)
+	if err != nil {
+		return err
+	}
+
+	assets, err := s.assetStorage.SaveAssets(ctx, BucketName, thumbBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("failed saving thumbnail asset")
+		return ErrInternal
+	}
+	thumbId := assets[0].Id
+
+	set, err := s.metaRepo.SetImageProcessed(ctx, id, thumbId, watermarkedId, placeholder)
+	if err != nil || !set {
+		// assets aren't referenced by image, it is processed by another worker or failed
+		released := []string{thumbId}
+		if watermarkedId != "" {
+			released = append(released, watermarkedId)
+		}
+		if err := s.assetStorage.ReleaseAssets(ctx, released...); err != nil {
+			log.Error().Err(err).Msgf("failed releasing image (id=%s) processed assets", id)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed setting image (id=%s) thumbnail", id)
+		return ErrInternal
+	}
+	if !set {
+		log.Info().Msgf("image (id=%s) isn't processing anymore, processed assets are released", id)
+		return nil
+	}
+
+	sources := map[string][]byte{publicId: publicBytes, thumbId: thumbBytes}
+	for sourceId, sourceBytes := range sources {
+		variant, err := s.metaRepo.GetImageVariant(ctx, sourceId, FormatWebp)
+		if err != nil || variant != nil {
+			continue
+		}
+		if _, _, err := s.saveVariant(ctx, sourceId, sourceBytes, FormatWebp); err != nil {
+			log.Warning().Err(err).Msgf("failed creating variant (sourceId=%s), will be created on demand", sourceId)
+		}
+	}
+
+	log.Info().Msgf("processed image (id=%s, thumbnailId=%s)", id, thumbId)
+	s.processedCounter.Inc()
+
+	return nil
+}
+
+// Reads images from processing queue until context is done
+func (s *Service) RunWorker(ctx context.Context, consumer string) {
+	s.log.Info().Msgf("started image processing worker %s", consumer)
+
+	for ctx.Err() == nil {
+		messages, err := s.broker.GetImagesToProcess(ctx, consumer, processingBatchSize, processingBlockTime)
+		if err != nil {
+			s.log.Error().Err(err).Msg("failed reading images processing queue")
+			select {
+			case <-ctx.Done():
+			case <-time.After(processingBlockTime):
+			}
+			continue
+		}
+
+		for _, msg := range messages {
+			err := s.ProcessImage(ctx, msg.Value)
+			if err == ErrInternal {
+				// not acked, will be claimed again later
+				continue
+			}
+			if err != nil && err != ErrImageNotFound {
+				s.log.Warning().Err(err).Msgf("dropped image (id=%s) from processing queue", msg.Value)
+				// marked failed, so it isn't shown as processing forever, otherwise retried
+				if err := s.failImage(ctx, msg.Value); err != nil {
+					continue
+				}
+			}
+
+			if err := s.broker.AckImagesToProcess(ctx, msg.Id); err != nil {
+				s.log.Error().Err(err).Msgf("failed acking image (id=%s) processing", msg.Value)
+			}
+		}
+	}
+
+	s.log.Info().Msgf("stopped image processing worker %s", consumer)
+}
+
+func (s *Service) failImage(ctx context.Context, id string) error {
+	if err := s.metaRepo.SetImageFailed(ctx, id); err != nil {
+		s.log.WithContext(ctx).Error().Err(err).Msgf("failed marking image (id=%s) failed", id)
+		return err
+	}
+	s.failedCounter.Inc()
+	return nil
+}
+
+// Puts images processing too long to queue again: their messages could be lost by failed
+// enqueue, or they are retried after internal errors. Processed image is skipped by worker
+func (s *Service) SweepStaleImages(ctx context.Context) int {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::SweepStaleImages")
+	defer span.End()
+
+	count := 0
+	for ctx.Err() == nil {
+		ids, err := s.metaRepo.ClaimStaleImages(ctx, ProcessingStaleAfter, processingBatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("failed getting stale processing images")
+			break
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		if err := s.broker.PutImagesToProcess(ctx, ids...); err != nil {
+			log.Error().Err(err).Msg("failed putting stale images to processing queue")
+			break
+		}
+		count += len(ids)
+	}
+
+	if count > 0 {
+		log.Warning().Msgf("put %d stale images to processing queue again", count)
+	}
+	return count
+}
+
+func (s *Service) RunStaleImagesSweeper(ctx context.Context, interval time.Duration) {
+	s.log.Info().Msgf("started stale images sweeper with interval %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.SweepStaleImages(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.log.Info().Msg("stopped stale images sweeper")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package image
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const regenerationBatchSize = 50
+
+type RegenerationProgress struct {
+	Running    bool
+	Total      int
+	Processed  int
+	Failed     int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+type regenerationJob struct {
+	mutex    sync.Mutex
+	progress RegenerationProgress
+}
+
+func (j *regenerationJob) update(fn func(p *RegenerationProgress)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	fn(&j.progress)
+}
+
+func (s *Service) ThumbnailsRegenerationProgress() RegenerationProgress {
+	s.regeneration.mutex.Lock()
+	defer s.regeneration.mutex.Unlock()
+	return s.regeneration.progress
+}
+
+// Starts background job, which recreates thumbnails created before the job start with current settings.
+// Job runs until it's finished or jobCtx is done, request ctx is used only to start it
+func (s *Service) StartThumbnailsRegeneration(ctx, jobCtx context.Context) error {
+	log := s.log.WithContext(ctx)
+
+	if s.ThumbnailsRegenerationProgress().Running {
+		return ErrJobRunning
+	}
+
+	// thumbnails are counted without lock, so progress isn't blocked by slow count
+	startedAt := time.Now()
+	total, err := s.metaRepo.CountThumbnails(ctx, startedAt)
+	if err != nil {
+		log.Error().Err(err).Msg("failed counting thumbnails")
+		return ErrInternal
+	}
+
+	s.regeneration.mutex.Lock()
+	defer s.regeneration.mutex.Unlock()
+
+	if s.regeneration.progress.Running {
+		return ErrJobRunning
+	}
+
+	s.regeneration.progress = RegenerationProgress{
+		Running:   true,
+		Total:     total,
+		StartedAt: startedAt,
+	}
+
+	go s.regenerateThumbnails(jobCtx, startedAt)
+
+	log.Info().Msgf("started thumbnails regeneration, total=%d", total)
+	return nil
+}
+
+func (s *Service) regenerateThumbnails(ctx context.Context, createdBefore time.Time) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::regenerateThumbnails")
+	defer span.End()
+
+	defer s.regeneration.update(func(p *RegenerationProgress) {
+		p.Running = false
+		p.FinishedAt = time.Now()
+	})
+
+	after := ""
+	for {
+		if ctx.Err() != nil {
+			log.Info().Msg("thumbnails regeneration is stopped on shutdown")
+			return
+		}
+
+		batch, err := s.metaRepo.GetThumbnailsBatch(ctx, after, createdBefore, regenerationBatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("failed getting thumbnails batch, regeneration stopped")
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		failed := 0
+		for _, thumb := range batch {
+			if err := s.regenerateThumbnail(ctx, thumb); err != nil {
+				failed++
+			}
+		}
+		after = batch[len(batch)-1].ThumbnailId
+
+		s.regeneration.update(func(p *RegenerationProgress) {
+			p.Processed += len(batch)
+			p.Failed += failed
+		})
+
+		progress := s.ThumbnailsRegenerationProgress()
+		log.Info().Msgf("thumbnails regeneration progress %d/%d, failed=%d", progress.Processed, progress.Total, progress.Failed)
+	}
+
+	log.Info().Msg("finished thumbnails regeneration")
+}
+
+func (s *Service) regenerateThumbnail(ctx context.Context, thumb ThumbnailSourceDTO) error {
+	log := s.log.WithContext(ctx)
+
+	sourceBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, thumb.SourceId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting thumbnail source (assetId=%s)", thumb.SourceId)
+		return ErrInternal
+	}
+
+	img, err := s.decodeImage(ctx, sourceBytes)
+	if err != nil {
+		return err
+	}
+
+	thumbBytes, err := s.createThumbnail(ctx, img)
+	if err != nil {
+		return err
+	}
+
+	assets, err := s.assetStorage.SaveAssets(ctx, BucketName, thumbBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("failed saving thumbnail asset")
+		return ErrInternal
+	}
+
+	released, err := s.metaRepo.SwapThumbnail(ctx, thumb.ThumbnailId, assets[0].Id)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed swapping thumbnail (old=%s, new=%s)", thumb.ThumbnailId, assets[0].Id)
+		return ErrInternal
+	}
+
+	if len(released) == 0 {
+		// images with old thumbnail are deleted or regenerated meanwhile, new one isn't referenced
+		log.Info().Msgf("thumbnail (id=%s) isn't used anymore, new one is released", thumb.ThumbnailId)
+		released = []string{assets[0].Id}
+	}
+	if err := s.assetStorage.ReleaseAssets(ctx, released...); err != nil {
+		log.Error().Err(err).Msgf("failed releasing thumbnails (ids=%v)", released)
+	}
+
+	return nil
+}
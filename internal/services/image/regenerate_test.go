@@ -0,0 +1,186 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"shorty/internal/common/logging"
+	"shorty/internal/common/metrics"
+	"shorty/internal/databases/blob"
+	"shorty/internal/services/assets"
+	"shorty/internal/services/assets/assetstest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Images repository keeping thumbnails in memory, batches wait for unblock when it's set.
+// Swapped thumbnails are created after job start, so they aren't returned in batches
+type memoryThumbnails struct {
+	MetadataRepo
+	mutex      sync.Mutex
+	thumbnails []ThumbnailSourceDTO
+	swapped    map[string]bool
+	unblock    chan struct{}
+	// Thumbnail removed right before it is swapped, as if its images are deleted concurrently
+	deleteOnSwap string
+}
+
+func (r *memoryThumbnails) CountThumbnails(ctx context.Context, createdBefore time.Time) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.thumbnails), nil
+}
+
+func (r *memoryThumbnails) GetThumbnailsBatch(ctx context.Context, after string, createdBefore time.Time, limit int) ([]ThumbnailSourceDTO, error) {
+	if r.unblock != nil {
+		select {
+		case <-r.unblock:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	batch := []ThumbnailSourceDTO{}
+	for _, thumb := range r.thumbnails {
+		if thumb.ThumbnailId > after && !r.swapped[thumb.ThumbnailId] && len(batch) < limit {
+			batch = append(batch, thumb)
+		}
+	}
+	return batch, nil
+}
+
+func (r *memoryThumbnails) SwapThumbnail(ctx context.Context, oldId, newId string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if oldId == r.deleteOnSwap {
+		r.thumbnails = slices.DeleteFunc(r.thumbnails, func(thumb ThumbnailSourceDTO) bool { return thumb.ThumbnailId == oldId })
+	}
+
+	var released []string
+	for i := range r.thumbnails {
+		if r.thumbnails[i].ThumbnailId == oldId {
+			r.thumbnails[i].ThumbnailId = newId
+			released = []string{oldId}
+		}
+	}
+	r.swapped[newId] = true
+	return released, nil
+}
+
+func newRegenerationService(t *testing.T, thumbnails *memoryThumbnails, broker *assetstest.Broker, sources int) *Service {
+	t.Helper()
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := noop.NewTracerProvider().Tracer("test")
+
+	storage := assets.NewStorage(assetstest.NewRepo(), assetstest.Cache{}, blob.NewMemory(), broker, nil, logger, tracer)
+
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	img.Set(10, 10, color.White)
+	buff := bytes.NewBuffer(nil)
+	if err := png.Encode(buff, img); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < sources; i++ {
+		saved, err := storage.SaveAssets(context.Background(), BucketName, buff.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		thumbnails.thumbnails = append(thumbnails.thumbnails, ThumbnailSourceDTO{ThumbnailId: string(rune('a' + i)), SourceId: saved[0].Id})
+	}
+
+	return NewService(thumbnails, storage, broker, nil, DefaultConfig(), logger, tracer, metrics.NewNoop())
+}
+
+func waitRegeneration(t *testing.T, service *Service) RegenerationProgress {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if progress := service.ThumbnailsRegenerationProgress(); !progress.Running {
+			return progress
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("regeneration isn't finished in time")
+	return RegenerationProgress{}
+}
+
+func TestThumbnailsRegeneration(t *testing.T) {
+	thumbnails, broker := &memoryThumbnails{swapped: map[string]bool{}}, &assetstest.Broker{}
+	service := newRegenerationService(t, thumbnails, broker, 3)
+
+	if err := service.StartThumbnailsRegeneration(context.Background(), context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	progress := waitRegeneration(t, service)
+
+	if progress.Total != 3 || progress.Processed != 3 || progress.Failed != 0 || progress.FinishedAt.IsZero() {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	for _, thumb := range thumbnails.thumbnails {
+		if len(thumb.ThumbnailId) == 1 {
+			t.Fatalf("thumbnail of source %s isn't swapped", thumb.SourceId)
+		}
+	}
+	if len(broker.Released) != 3 {
+		t.Fatalf("expected old thumbnails released, got %v", broker.Released)
+	}
+}
+
+// Thumbnail of images deleted meanwhile isn't swapped, its regenerated one is released instead
+func TestThumbnailsRegenerationOfDeletedImages(t *testing.T) {
+	thumbnails, broker := &memoryThumbnails{swapped: map[string]bool{}, deleteOnSwap: "b"}, &assetstest.Broker{}
+	service := newRegenerationService(t, thumbnails, broker, 2)
+
+	if err := service.StartThumbnailsRegeneration(context.Background(), context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if progress := waitRegeneration(t, service); progress.Processed != 2 || progress.Failed != 0 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+
+	if len(thumbnails.thumbnails) != 1 || len(broker.Released) != 2 {
+		t.Fatalf("expected one swapped thumbnail and two released, got %v", broker.Released)
+	}
+	if released := broker.Released[1]; released == "b" || !thumbnails.swapped[released] {
+		t.Fatalf("expected regenerated thumbnail released, got %s", released)
+	}
+}
+
+// Job outlives request starting it and is stopped with job context
+func TestThumbnailsRegenerationStopped(t *testing.T) {
+	thumbnails := &memoryThumbnails{swapped: map[string]bool{}, unblock: make(chan struct{})}
+	service := newRegenerationService(t, thumbnails, &assetstest.Broker{}, 1)
+
+	requestCtx, cancelRequest := context.WithCancel(context.Background())
+	jobCtx, cancelJob := context.WithCancel(context.Background())
+	defer cancelJob()
+
+	if err := service.StartThumbnailsRegeneration(requestCtx, jobCtx); err != nil {
+		t.Fatal(err)
+	}
+	cancelRequest()
+
+	if err := service.StartThumbnailsRegeneration(context.Background(), jobCtx); err != ErrJobRunning {
+		t.Fatalf("expected job running error, got %v", err)
+	}
+	if !service.ThumbnailsRegenerationProgress().Running {
+		t.Fatal("expected job running after request is done")
+	}
+
+	cancelJob()
+	progress := waitRegeneration(t, service)
+	if progress.Processed != 0 || progress.FinishedAt.IsZero() {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+}
@@ -3,6 +3,7 @@ package image
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -11,8 +12,11 @@ import (
 	"shorty/internal/common/broker"
 	"shorty/internal/common/logging"
 	"shorty/internal/common/metrics"
+	"shorty/internal/common/scanner"
 	"shorty/internal/services/assets"
+	"time"
 
+	"github.com/HugoSmits86/nativewebp"
 	"github.com/anthonynsimon/bild/transform"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -22,99 +26,266 @@ var (
 	ErrImageNotFound     = fmt.Errorf("image not found")
 	ErrImageTooLarge     = fmt.Errorf("image too large")
 	ErrInvalidFormat     = fmt.Errorf("invalid format")
+	ErrImageEmpty        = fmt.Errorf("image has zero size")
+	ErrImageDimensions   = fmt.Errorf("image dimensions too large")
+	ErrImageProcessing   = fmt.Errorf("image is processing")
+	ErrInvalidEdit       = fmt.Errorf("invalid edit options")
+	ErrJobRunning        = fmt.Errorf("job is already running")
+	ErrImageInfected     = fmt.Errorf("image contains malware")
+	ErrScan              = fmt.Errorf("image can't be scanned for malware, try again later")
 	ErrInternal          = fmt.Errorf("internal error")
+
+	errAssetDeleted = fmt.Errorf("image asset is deleted")
 )
 
 const (
 	BucketName   = "images"
 	MaxImageSize = 5 * 1024 * 1024
+
+	PlaceholderWidth   = 16
+	PlaceholderQuality = 50
+	WatermarkedQuality = 90
 )
 
-func NewService(metaRepo MetadataRepo, assetsStorage *assets.Storage, log logging.Logger, tracer trace.Tracer, meter metrics.Meter) *Service {
+func NewService(metaRepo MetadataRepo, assetsStorage *assets.Storage, broker broker.Broker, malwareScanner *scanner.Checker, config Config, log logging.Logger, tracer trace.Tracer, meter metrics.Meter) *Service {
 	return &Service{
 		log:                   log.WithService("images"),
 		tracer:                tracer,
+		config:                config,
+		broker:                broker,
 		assetStorage:          assetsStorage,
 		metaRepo:              metaRepo,
+		malwareScanner:        malwareScanner,
 		uploadsCounter:        meter.NewCounter("images_uploads", "Count of uploaded images"),
 		dulicatesCounter:      meter.NewCounter("images_duplicates", "Count of uploaded duplicates"),
 		origDownloadsCounter:  meter.NewCounter("images_orig_downloads", "How many times original image was downloaded"),
 		thumbDownloadsCounter: meter.NewCounter("images_thumb_downloads", "How many times thumbnail of image was downloaded"),
+		variantsCounter:       meter.NewCounter("images_variants", "Count of generated image variants"),
+		processedCounter:      meter.NewCounter("images_processed", "Count of processed images"),
+		failedCounter:         meter.NewCounter("images_failed", "Count of images, which can't be processed"),
+		editsCounter:          meter.NewCounter("images_edits", "Count of edited images"),
+		infectedCounter:       meter.NewCounter("images_infected", "Count of image uploads with detected malware"),
 	}
 }
 
 type Service struct {
 	log          logging.Logger
 	tracer       trace.Tracer
+	config       Config
 	broker       broker.Broker
 	assetStorage *assets.Storage
 	metaRepo     MetadataRepo
+	regeneration regenerationJob
+
+	malwareScanner *scanner.Checker
 
 	uploadsCounter        metrics.Counter
 	dulicatesCounter      metrics.Counter
 	origDownloadsCounter  metrics.Counter
 	thumbDownloadsCounter metrics.Counter
+	variantsCounter       metrics.Counter
+	processedCounter      metrics.Counter
+	failedCounter         metrics.Counter
+	editsCounter          metrics.Counter
+	infectedCounter       metrics.Counter
 }
 
-func (s *Service) createThumbnail(ctx context.Context, imgBytes []byte) ([]byte, error) {
+// Checks image header before decoding, so oversized images are never decompressed
+func (s *Service) checkImage(ctx context.Context, r io.Reader) (*image.Config, Format, error) {
 	log := s.log.WithContext(ctx)
 
-	ctx, span := s.tracer.Start(ctx, "image::createThumbnail")
-	defer span.End()
-
-	imgReader := bytes.NewReader(imgBytes)
-	imgInfo, format, err := image.DecodeConfig(imgReader)
+	imgInfo, format, err := image.DecodeConfig(r)
 	if err != nil {
-		log.Error().Err(err).Msg("failed decoding image")
-		return nil, ErrInvalidFormat
+		log.Error().Err(err).Msg("failed decoding image config")
+		return nil, "", ErrInvalidFormat
 	}
 	if format != "jpeg" { //&& format != "png" {
 		log.Info().Msgf("rejected image with unsupported format %s", format)
-		return nil, ErrUnsupportedFormat
+		return nil, "", ErrUnsupportedFormat
+	}
+	if err := s.config.checkDimensions(imgInfo.Width, imgInfo.Height); err != nil {
+		log.Info().Msgf("rejected image with dimensions %dx%d", imgInfo.Width, imgInfo.Height)
+		return nil, "", err
 	}
 
-	imgReader.Seek(0, io.SeekStart)
-	img, _, err := image.Decode(imgReader)
+	return &imgInfo, Format(format), nil
+}
+
+func (s *Service) decodeImage(ctx context.Context, imgBytes []byte) (image.Image, error) {
+	log := s.log.WithContext(ctx)
+
+	_, span := s.tracer.Start(ctx, "image::decodeImage")
+	defer span.End()
+
+	img, _, err := image.Decode(bytes.NewReader(imgBytes))
 	if err != nil {
 		log.Error().Err(err).Msg("failed decoding image")
 		return nil, ErrInvalidFormat
 	}
 
-	width := 200
-	height := width * imgInfo.Height / imgInfo.Width
+	return img, nil
+}
 
-	resized := transform.Resize(img, width, height, transform.Linear)
+func encodeJpeg(img image.Image, quality int) ([]byte, error) {
 	buff := bytes.NewBuffer(nil)
-	if err := jpeg.Encode(buff, resized, nil); err != nil {
+	if err := jpeg.Encode(buff, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func resizeToJpeg(img image.Image, width int, quality int) ([]byte, error) {
+	bounds := img.Bounds()
+	height := max(1, width*bounds.Dy()/bounds.Dx())
+
+	resized := transform.Resize(img, width, height, transform.Linear)
+	return encodeJpeg(resized, quality)
+}
+
+func (s *Service) createThumbnail(ctx context.Context, img image.Image) ([]byte, error) {
+	log := s.log.WithContext(ctx)
+
+	_, span := s.tracer.Start(ctx, "image::createThumbnail")
+	defer span.End()
+
+	thumbBytes, err := resizeToJpeg(img, s.config.ThumbnailWidth, s.config.ThumbnailQuality)
+	if err != nil {
 		log.Error().Err(err).Msg("failed encoding thumbnail")
 		return nil, ErrInternal
 	}
 
-	return buff.Bytes(), nil
+	return thumbBytes, nil
 }
 
-func (s *Service) UploadImage(ctx context.Context, name string, imageBytes []byte) (*ImageMetadataDTO, error) {
+// Creates tiny low quality image (LQIP), which is shown until thumbnail or original is loaded
+func (s *Service) createPlaceholder(ctx context.Context, img image.Image) (string, error) {
+	log := s.log.WithContext(ctx)
+
+	_, span := s.tracer.Start(ctx, "image::createPlaceholder")
+	defer span.End()
+
+	placeholderBytes, err := resizeToJpeg(img, PlaceholderWidth, PlaceholderQuality)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encoding placeholder")
+		return "", ErrInternal
+	}
+
+	return base64.StdEncoding.EncodeToString(placeholderBytes), nil
+}
+
+func (s *Service) WatermarkEnabled() bool {
+	return s.config.Watermark.Enabled()
+}
+
+// Returns id of original asset served publicly, it is empty while watermark is not applied yet
+func publicOriginalId(meta *ImageMetadataExDTO) string {
+	if meta.Watermark {
+		return meta.WatermarkedId
+	}
+	return meta.OriginalId
+}
+
+// Reads image stream twice: first to check and hash it, then to save it,
+// so image is never fully loaded into memory on upload. Duplicate deleted after
+// it is found is a deduplication miss, so image is uploaded again
+func (s *Service) UploadImage(ctx context.Context, name string, r io.ReadSeeker, size int64, watermark bool, retention time.Duration) (*ImageMetadataDTO, error) {
 	log := s.log.WithContext(ctx)
 
 	ctx, span := s.tracer.Start(ctx, "image::UploadImage")
 	defer span.End()
 
-	imageSize := len(imageBytes)
-	if imageSize > MaxImageSize { //temporary 15MB max
-		log.Info().Msgf("rejected too heavy image with size %d", imageSize)
+	meta, err := s.uploadImage(ctx, name, r, size, watermark, retention)
+	if err != errAssetDeleted {
+		return meta, err
+	}
+
+	log.Info().Msgf("duplicate of image %s is deleted, uploading it again", name)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		log.Error().Err(err).Msg("err rewinding image stream")
+		return nil, ErrInternal
+	}
+	meta, err = s.uploadImage(ctx, name, r, size, watermark, retention)
+	if err == errAssetDeleted {
+		return nil, ErrInternal
+	}
+	return meta, err
+}
+
+func (s *Service) uploadImage(ctx context.Context, name string, r io.ReadSeeker, size int64, watermark bool, retention time.Duration) (*ImageMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	if size > MaxImageSize { //temporary 15MB max
+		log.Info().Msgf("rejected too heavy image with size %d", size)
 		return nil, ErrImageTooLarge
 	}
 
-	imageHash := common.NewAssetHash(imageBytes)
-	info, err := s.metaRepo.GetImageMetadataDuplicate(ctx, imageSize, imageHash)
+	imgInfo, format, err := s.checkImage(ctx, r)
 	if err != nil {
-		log.Error().Err(err).Msg("failed getting img info by hash")
+		return nil, err
+	}
+
+	hasher := common.NewAssetHasher()
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		log.Error().Err(err).Msg("failed rewinding image stream")
+		return nil, ErrInternal
+	}
+	if _, err := io.Copy(hasher, io.LimitReader(r, size)); err != nil {
+		log.Error().Err(err).Msg("failed hashing image stream")
 		return nil, ErrInternal
 	}
 
 	metadata := ImageMetadataDTO{
-		Id:   common.NewShortId(32),
-		Name: name,
+		Id:        common.NewShortId(32),
+		Name:      name,
+		Width:     imgInfo.Width,
+		Height:    imgInfo.Height,
+		Format:    format,
+		Watermark: watermark && s.config.Watermark.Enabled(),
+		Status:    ImageProcessing,
+		ExpiresAt: common.NewExpiresAt(retention),
+	}
+
+	imageHash := common.AssetHasherSum(hasher)
+	info, err := s.metaRepo.GetImageMetadataDuplicate(ctx, int(size), imageHash, metadata.Watermark)
+	if err != nil {
+		log.Error().Err(err).Msg("failed getting img info by hash")
+		return nil, ErrInternal
+	}
+
+	// original of image with another watermark option is reused, but processed again
+	var original *assets.AssetMetadataDTO
+	if info != nil {
+		original = &assets.AssetMetadataDTO{Id: info.OriginalId, Scanned: info.OriginalScanned}
+	} else {
+		original, err = s.assetStorage.GetAssetDuplicate(ctx, BucketName, int(size), imageHash)
+		if err != nil {
+			return nil, ErrInternal
+		}
+	}
+
+	// original saved without scan has same content as stream, so it is checked by stream scan
+	var result *scanner.Result
+	if original == nil || !original.Scanned {
+		result, err = s.malwareScanner.CheckStream(ctx, r, size)
+		if err != nil {
+			log.Error().Err(err).Msg("failed scanning image stream")
+			return nil, ErrScan
+		}
+		if result.Infected {
+			log.Warning().Msgf("detected malware %s in image %s", result.Signature, name)
+			s.infectedCounter.Inc()
+			if original != nil {
+				if err := s.assetStorage.QuarantineAsset(ctx, original.Id); err != nil {
+					log.Error().Err(err).Msg("failed quarantining infected image duplicate")
+				}
+			} else if _, err := s.assetStorage.QuarantineAssetStream(ctx, BucketName, r, size); err != nil {
+				log.Error().Err(err).Msg("failed saving infected image to quarantine")
+			}
+			return nil, ErrImageInfected
+		}
+		if !result.Scanned {
+			log.Warning().Msgf("image %s is saved without malware scan", name)
+		}
 	}
 
 	if info != nil {
@@ -122,29 +293,46 @@ func (s *Service) UploadImage(ctx context.Context, name string, imageBytes []byt
 		s.dulicatesCounter.Inc()
 		metadata.OriginalId = info.OriginalId
 		metadata.ThumbnailId = info.ThumbnailId
+		metadata.WatermarkedId = info.WatermarkedId
+		metadata.Placeholder = info.Placeholder
+		metadata.Status = info.Status
+	} else if original != nil {
+		log.Info().Msg("found existing original with same hash, processing it again")
+		s.dulicatesCounter.Inc()
+		metadata.OriginalId = original.Id
 	} else {
-		log.Info().Msg("not found existing files with same hash, saving img and thumb to storage...")
+		log.Info().Msg("not found existing files with same hash, saving img to storage...")
 
-		thumbBytes, err := s.createThumbnail(ctx, imageBytes)
-		if err != nil {
-			return nil, err
-		}
-
-		assets, err := s.assetStorage.SaveAssets(ctx, BucketName, imageBytes, thumbBytes)
+		asset, err := s.assetStorage.SaveAssetStream(ctx, BucketName, r, size)
 		if err != nil {
 			log.Error().Err(err).Msg("failed saving assets")
 			return nil, ErrInternal
 		}
 
-		metadata.OriginalId = assets[0].Id
-		metadata.ThumbnailId = assets[1].Id
+		metadata.OriginalId = asset.Id
+	}
+
+	// failing to mark original only makes its duplicates scanned again
+	if result != nil && result.Scanned {
+		s.assetStorage.MarkAssetScanned(ctx, metadata.OriginalId)
 	}
 
-	err = s.metaRepo.SaveImageMetadata(ctx, metadata)
+	saved, err := s.metaRepo.SaveImageMetadata(ctx, metadata)
 	if err != nil {
 		log.Error().Err(err).Msg("failed saving image metadata")
 		return nil, ErrInternal
 	}
+	if !saved {
+		log.Warning().Msgf("image assets (originalId=%s) are deleted before image is saved", metadata.OriginalId)
+		return nil, errAssetDeleted
+	}
+
+	if metadata.Status == ImageProcessing {
+		// image is saved already, it is put to queue again by stale images sweeper
+		if err := s.broker.PutImagesToProcess(ctx, metadata.Id); err != nil {
+			log.Error().Err(err).Msg("failed putting image to processing queue")
+		}
+	}
 
 	log.Info().Msgf("created image with id=%s", metadata.Id)
 	s.uploadsCounter.Inc()
@@ -167,16 +355,25 @@ func (s *Service) GetImageMetadata(ctx context.Context, id string) (*ImageMetada
 		log.Info().Msgf("not found image with id=%s", id)
 		return nil, ErrImageNotFound
 	}
+	if common.IsExpired(meta.ExpiresAt) {
+		log.Info().Msgf("image with id=%s is expired", id)
+		return nil, ErrImageNotFound
+	}
+	if meta.Status == ImageFailed {
+		log.Info().Msgf("image with id=%s failed processing", id)
+		return nil, ErrImageNotFound
+	}
 
 	log.Info().Msgf("read image metadata (id=%s)", id)
 
 	return meta, nil
 }
 
-func (s *Service) GetImageBytes(ctx context.Context, id string, thumbnail bool) ([]byte, error) {
+// Returns image asset, its body must be closed by caller
+func (s *Service) GetImage(ctx context.Context, id string, thumbnail bool) (*assets.AssetDTO, error) {
 	log := s.log.WithContext(ctx)
 
-	ctx, span := s.tracer.Start(ctx, "image::GetImageBytes")
+	ctx, span := s.tracer.Start(ctx, "image::GetImage")
 	defer span.End()
 
 	meta, err := s.GetImageMetadata(ctx, id)
@@ -184,14 +381,15 @@ func (s *Service) GetImageBytes(ctx context.Context, id string, thumbnail bool)
 		return nil, err
 	}
 
-	assetId := meta.OriginalId
-	if thumbnail {
-		assetId = meta.ThumbnailId
+	assetId := variantSourceId(meta, thumbnail)
+	if assetId == "" {
+		log.Info().Msgf("image (id=%s) is still processing", id)
+		return nil, ErrImageProcessing
 	}
 
-	assetBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, assetId)
+	asset, err := s.assetStorage.GetAsset(ctx, BucketName, assetId)
 	if err != nil {
-		log.Error().Err(err).Msgf("failed getting image asset bytes from storage (id=%s, assetId=%s, thumbnail=%t)", id, assetId, thumbnail)
+		log.Error().Err(err).Msgf("failed getting image asset from storage (id=%s, assetId=%s, thumbnail=%t)", id, assetId, thumbnail)
 		return nil, ErrInternal
 	}
 
@@ -202,5 +400,208 @@ func (s *Service) GetImageBytes(ctx context.Context, id string, thumbnail bool)
 		s.origDownloadsCounter.Inc()
 	}
 
-	return assetBytes, nil
+	return asset, nil
+}
+
+// Encodes image into given format. AVIF is not supported, there is no pure-go encoder for it
+func (s *Service) createVariant(ctx context.Context, imgBytes []byte, format Format) ([]byte, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::createVariant")
+	defer span.End()
+
+	img, _, err := image.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		log.Error().Err(err).Msg("failed decoding image")
+		return nil, ErrInvalidFormat
+	}
+
+	buff := bytes.NewBuffer(nil)
+	switch format {
+	case FormatWebp:
+		err = nativewebp.Encode(buff, img, nil)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed encoding image to %s", format)
+		return nil, ErrInternal
+	}
+
+	return buff.Bytes(), nil
+}
+
+// Encodes and stores variant of source asset. If variant is not smaller
+// than source, then source asset is referenced instead
+func (s *Service) saveVariant(ctx context.Context, sourceId string, sourceBytes []byte, format Format) (*ImageVariantDTO, []byte, error) {
+	log := s.log.WithContext(ctx)
+
+	variantBytes, err := s.createVariant(ctx, sourceBytes, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	variant := &ImageVariantDTO{
+		SourceId: sourceId,
+		Format:   format,
+		AssetId:  sourceId,
+	}
+	resultBytes := sourceBytes
+
+	if len(variantBytes) < len(sourceBytes) {
+		assets, err := s.assetStorage.SaveAssets(ctx, BucketName, variantBytes)
+		if err != nil {
+			log.Error().Err(err).Msg("failed saving variant asset")
+			return nil, nil, ErrInternal
+		}
+		variant.AssetId = assets[0].Id
+		resultBytes = variantBytes
+	} else {
+		log.Info().Msgf("%s variant is not smaller than source (sourceId=%s), referencing source", format, sourceId)
+	}
+
+	storedId, err := s.metaRepo.SaveImageVariant(ctx, *variant)
+	if (err != nil || storedId != variant.AssetId) && variant.AssetId != sourceId {
+		// variant asset isn't referenced, it is created by another request or saving failed
+		if err := s.assetStorage.ReleaseAssets(ctx, variant.AssetId); err != nil {
+			log.Error().Err(err).Msgf("failed releasing variant asset (id=%s)", variant.AssetId)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed saving image variant")
+		return nil, nil, ErrInternal
+	}
+	if storedId != variant.AssetId {
+		log.Info().Msgf("%s variant is saved concurrently (sourceId=%s), using stored one", format, sourceId)
+		variant.AssetId, resultBytes = storedId, variantBytes
+		if storedId == sourceId {
+			resultBytes = sourceBytes
+		}
+	}
+
+	log.Info().Msgf("created image variant (sourceId=%s, format=%s, assetId=%s)", sourceId, format, variant.AssetId)
+	s.variantsCounter.Inc()
+
+	return variant, resultBytes, nil
+}
+
+func variantSourceId(meta *ImageMetadataExDTO, thumbnail bool) string {
+	if thumbnail {
+		return meta.ThumbnailId
+	}
+	return publicOriginalId(meta)
+}
+
+// Returns id of stored image asset in requested format, so conditional request is answered
+// without opening asset. Empty id means asset isn't stored yet
+func (s *Service) GetImageVariantAssetId(ctx context.Context, meta *ImageMetadataExDTO, thumbnail bool, format Format) (string, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::GetImageVariantAssetId")
+	defer span.End()
+
+	sourceId := variantSourceId(meta, thumbnail)
+	if sourceId == "" || format == FormatJpeg {
+		return sourceId, nil
+	}
+
+	variant, err := s.metaRepo.GetImageVariant(ctx, sourceId, format)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting image variant (id=%s, sourceId=%s, format=%s)", meta.Id, sourceId, format)
+		return "", ErrInternal
+	}
+	if variant == nil {
+		return "", nil
+	}
+	return variant.AssetId, nil
+}
+
+// Returns image asset in requested format and format of returned asset.
+// Variant is generated once and stored as an asset, jpeg is returned when
+// variant references the source. Asset body must be closed by caller.
+func (s *Service) GetImageVariant(ctx context.Context, id string, thumbnail bool, format Format) (*assets.AssetDTO, Format, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::GetImageVariant")
+	defer span.End()
+
+	if format == FormatJpeg {
+		asset, err := s.GetImage(ctx, id, thumbnail)
+		return asset, FormatJpeg, err
+	}
+
+	meta, err := s.GetImageMetadata(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sourceId := variantSourceId(meta, thumbnail)
+	if sourceId == "" {
+		log.Info().Msgf("image (id=%s) is still processing", id)
+		return nil, "", ErrImageProcessing
+	}
+
+	variant, err := s.metaRepo.GetImageVariant(ctx, sourceId, format)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting image variant (id=%s, sourceId=%s, format=%s)", id, sourceId, format)
+		return nil, "", ErrInternal
+	}
+
+	var asset *assets.AssetDTO
+	if variant == nil {
+		sourceBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, sourceId)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed getting image asset bytes from storage (id=%s, assetId=%s)", id, sourceId)
+			return nil, "", ErrInternal
+		}
+
+		var assetBytes []byte
+		variant, assetBytes, err = s.saveVariant(ctx, sourceId, sourceBytes, format)
+		if err != nil {
+			return nil, "", err
+		}
+		asset = assets.NewBytesAsset(variant.AssetId, BucketName, assetBytes)
+	} else {
+		asset, err = s.assetStorage.GetAsset(ctx, BucketName, variant.AssetId)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed getting image variant from storage (id=%s, assetId=%s, format=%s)", id, variant.AssetId, format)
+			return nil, "", ErrInternal
+		}
+	}
+
+	log.Info().Msgf("read image variant (id=%s, assetId=%s, format=%s)", id, variant.AssetId, format)
+	if thumbnail {
+		s.thumbDownloadsCounter.Inc()
+	} else {
+		s.origDownloadsCounter.Inc()
+	}
+
+	if variant.AssetId == sourceId {
+		return asset, FormatJpeg, nil
+	}
+	return asset, format, nil
+}
+
+// Returns original image asset without watermark, its body must be closed by caller
+func (s *Service) GetImageRaw(ctx context.Context, id string) (*assets.AssetDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "image::GetImageRaw")
+	defer span.End()
+
+	meta, err := s.GetImageMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := s.assetStorage.GetAsset(ctx, BucketName, meta.OriginalId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting image raw original from storage (id=%s, assetId=%s)", id, meta.OriginalId)
+		return nil, ErrInternal
+	}
+
+	log.Info().Msgf("read image raw original (id=%s, assetId=%s)", id, meta.OriginalId)
+	s.origDownloadsCounter.Inc()
+
+	return asset, nil
 }
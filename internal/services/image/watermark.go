@@ -0,0 +1,114 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	"github.com/anthonynsimon/bild/transform"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+type WatermarkPosition string
+
+const (
+	WatermarkTopLeft     WatermarkPosition = "top-left"
+	WatermarkTopRight    WatermarkPosition = "top-right"
+	WatermarkBottomLeft  WatermarkPosition = "bottom-left"
+	WatermarkBottomRight WatermarkPosition = "bottom-right"
+	WatermarkCenter      WatermarkPosition = "center"
+)
+
+func ParseWatermarkPosition(value string) (WatermarkPosition, error) {
+	switch p := WatermarkPosition(value); p {
+	case WatermarkTopLeft, WatermarkTopRight, WatermarkBottomLeft, WatermarkBottomRight, WatermarkCenter:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown watermark position %s", value)
+}
+
+type WatermarkConfig struct {
+	Text     string
+	Image    image.Image
+	Position WatermarkPosition
+	Opacity  float64 // from 0 to 1
+	Scale    float64 // watermark width relative to image width
+}
+
+func LoadWatermarkImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return png.Decode(file)
+}
+
+func (w WatermarkConfig) Enabled() bool {
+	return w.Text != "" || w.Image != nil
+}
+
+// Renders text with basic bitmap font, shadow keeps it visible on light images
+func renderWatermarkText(text string) image.Image {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil() + 1
+	height := face.Metrics().Height.Ceil() + 1
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	drawer := &font.Drawer{Dst: img, Face: face}
+
+	for _, layer := range []struct {
+		offset int
+		color  color.Color
+	}{{1, color.Black}, {0, color.White}} {
+		drawer.Src = image.NewUniform(layer.color)
+		drawer.Dot = fixed.P(layer.offset, face.Metrics().Ascent.Ceil()+layer.offset)
+		drawer.DrawString(text)
+	}
+
+	return img
+}
+
+func (w WatermarkConfig) apply(img image.Image) image.Image {
+	mark := w.Image
+	if mark == nil {
+		mark = renderWatermarkText(w.Text)
+	}
+
+	bounds := img.Bounds()
+	markBounds := mark.Bounds()
+
+	markWidth := max(1, int(float64(bounds.Dx())*w.Scale))
+	markHeight := max(1, markWidth*markBounds.Dy()/markBounds.Dx())
+	mark = transform.Resize(mark, markWidth, markHeight, transform.Linear)
+
+	margin := min(bounds.Dx(), bounds.Dy()) / 50
+	x, y := bounds.Min.X+margin, bounds.Min.Y+margin
+	switch w.Position {
+	case WatermarkTopRight:
+		x = bounds.Max.X - markWidth - margin
+	case WatermarkBottomLeft:
+		y = bounds.Max.Y - markHeight - margin
+	case WatermarkCenter:
+		x = bounds.Min.X + (bounds.Dx()-markWidth)/2
+		y = bounds.Min.Y + (bounds.Dy()-markHeight)/2
+	case WatermarkBottomRight:
+		x = bounds.Max.X - markWidth - margin
+		y = bounds.Max.Y - markHeight - margin
+	}
+
+	result := image.NewRGBA(bounds)
+	draw.Draw(result, bounds, img, bounds.Min, draw.Src)
+
+	opacity := image.NewUniform(color.Alpha{uint8(255 * min(max(w.Opacity, 0), 1))})
+	markRect := image.Rect(x, y, x+markWidth, y+markHeight)
+	draw.DrawMask(result, markRect, mark, image.Point{}, opacity, image.Point{}, draw.Over)
+
+	return result
+}
@@ -0,0 +1,8 @@
+package pastes
+
+import "context"
+
+type MetadataRepo interface {
+	SavePasteMetadata(ctx context.Context, meta PasteMetadataDTO) (bool, error)
+	GetPasteMetadata(ctx context.Context, id string) (*PasteMetadataDTO, error)
+}
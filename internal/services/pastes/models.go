@@ -0,0 +1,33 @@
+package pastes
+
+import "time"
+
+type PasteMetadataDTO struct {
+	Id            string
+	Title         string
+	Language      string
+	Content       string // empty when content is stored as asset
+	AssetId       string // empty when content is stored inline
+	Highlighted   string // inline highlighted html of small paste, empty for pastes created before highlighting was stored
+	HighlightedId string // asset with highlighted html of large paste, empty for pastes created before highlighting was stored
+	Size          int
+	ExpiresAt     *time.Time
+	CreatedAt     time.Time
+}
+
+type PasteDTO struct {
+	Id          string
+	Title       string
+	Language    string
+	Content     string // empty when highlighted paste is requested
+	Highlighted string // empty when raw paste is requested
+	Size        int
+	ExpiresAt   *time.Time
+	CreatedAt   time.Time
+}
+
+type PasteOptions struct {
+	Title     string
+	Language  string        // empty means language is detected by content
+	Retention time.Duration // zero keeps paste forever
+}
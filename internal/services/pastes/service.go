@@ -0,0 +1,237 @@
+package pastes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"shorty/internal/common"
+	"shorty/internal/common/highlight"
+	"shorty/internal/common/logging"
+	"shorty/internal/common/metrics"
+	"shorty/internal/services/assets"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrInternal = errors.New("internal error")
+	ErrNotFound = errors.New("paste not found")
+	ErrEmpty    = errors.New("paste is empty")
+	ErrTooBig   = errors.New("paste too big")
+	ErrOptions  = errors.New("invalid paste options")
+)
+
+const (
+	BucketName  = "pastes"
+	MaxSize     = 1024 * 1024
+	MaxTitleLen = 256
+	// Larger pastes are stored as assets instead of database row
+	InlineMaxSize = 64 * 1024
+)
+
+func NewService(metaRepo MetadataRepo, assetsStorage *assets.Storage, log logging.Logger, tracer trace.Tracer, meter metrics.Meter) *Service {
+	return &Service{
+		log:            log.WithService("pastes"),
+		tracer:         tracer,
+		metaRepo:       metaRepo,
+		assetStorage:   assetsStorage,
+		createdCounter: meter.NewCounter("pastes_created", "Count of created pastes"),
+		viewsCounter:   meter.NewCounter("pastes_views", "Count of paste views"),
+	}
+}
+
+type Service struct {
+	log          logging.Logger
+	tracer       trace.Tracer
+	metaRepo     MetadataRepo
+	assetStorage *assets.Storage
+
+	createdCounter metrics.Counter
+	viewsCounter   metrics.Counter
+}
+
+func (s *Service) CreatePaste(ctx context.Context, content string, opts PasteOptions) (*PasteMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "pastes::CreatePaste")
+	defer span.End()
+
+	if strings.TrimSpace(content) == "" {
+		return nil, ErrEmpty
+	}
+	if len(content) > MaxSize {
+		log.Info().Msgf("rejected too big paste with size %d", len(content))
+		return nil, ErrTooBig
+	}
+	if !utf8.ValidString(content) || len(opts.Title) > MaxTitleLen || opts.Retention < 0 {
+		return nil, ErrOptions
+	}
+	if opts.Language != "" && !highlight.IsKnownLanguage(opts.Language) {
+		return nil, ErrOptions
+	}
+
+	meta := &PasteMetadataDTO{
+		Id:        common.NewShortId(16),
+		Title:     strings.TrimSpace(opts.Title),
+		Language:  opts.Language,
+		Size:      len(content),
+		ExpiresAt: common.NewExpiresAt(opts.Retention),
+		CreatedAt: time.Now().UTC(),
+	}
+	if meta.Language == "" {
+		meta.Language = highlight.DetectLanguage(content)
+	}
+
+	highlighted, err := highlight.Highlight(content, meta.Language)
+	if err != nil {
+		log.Error().Err(err).Msg("failed highlighting paste")
+		return nil, ErrInternal
+	}
+
+	if meta.Content, meta.AssetId, err = s.savePart(ctx, content); err != nil {
+		log.Error().Err(err).Msg("failed saving paste asset")
+		return nil, ErrInternal
+	}
+	if meta.Highlighted, meta.HighlightedId, err = s.savePart(ctx, highlighted); err != nil {
+		log.Error().Err(err).Msg("failed saving highlighted paste asset")
+		return nil, ErrInternal
+	}
+
+	saved, err := s.metaRepo.SavePasteMetadata(ctx, *meta)
+	if err != nil {
+		log.Error().Err(err).Msg("failed saving paste")
+		return nil, ErrInternal
+	}
+	if !saved {
+		log.Warning().Msgf("paste assets (assetId=%s, highlightedId=%s) are deleted before paste is saved", meta.AssetId, meta.HighlightedId)
+		released := []string{}
+		for _, id := range []string{meta.AssetId, meta.HighlightedId} {
+			if id != "" {
+				released = append(released, id)
+			}
+		}
+		if err := s.assetStorage.ReleaseAssets(ctx, released...); err != nil {
+			log.Error().Err(err).Msg("failed releasing paste assets")
+		}
+		return nil, ErrInternal
+	}
+
+	log.Info().Msgf("created paste with id=%s, size=%d", meta.Id, meta.Size)
+	s.createdCounter.Inc()
+
+	return meta, nil
+}
+
+// Returns paste with raw content
+func (s *Service) GetPaste(ctx context.Context, id string) (*PasteDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "pastes::GetPaste")
+	defer span.End()
+
+	meta, err := s.getPasteMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := s.readPart(ctx, meta.Content, meta.AssetId)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting paste (id=%s) asset", id)
+		return nil, ErrInternal
+	}
+
+	s.viewsCounter.Inc()
+
+	paste := newPasteDTO(meta)
+	paste.Content = content
+	return paste, nil
+}
+
+// Returns paste with html highlighted on creation, paste created before it is highlighted now
+func (s *Service) GetHighlightedPaste(ctx context.Context, id string) (*PasteDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "pastes::GetHighlightedPaste")
+	defer span.End()
+
+	meta, err := s.getPasteMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var highlighted string
+	if meta.Highlighted != "" || meta.HighlightedId != "" {
+		highlighted, err = s.readPart(ctx, meta.Highlighted, meta.HighlightedId)
+	} else {
+		highlighted, err = s.readPart(ctx, meta.Content, meta.AssetId)
+		if err == nil {
+			highlighted, err = highlight.Highlight(highlighted, meta.Language)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting highlighted paste (id=%s)", id)
+		return nil, ErrInternal
+	}
+
+	s.viewsCounter.Inc()
+
+	paste := newPasteDTO(meta)
+	paste.Highlighted = highlighted
+	return paste, nil
+}
+
+func (s *Service) getPasteMetadata(ctx context.Context, id string) (*PasteMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	meta, err := s.metaRepo.GetPasteMetadata(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting paste (id=%s)", id)
+		return nil, ErrInternal
+	}
+	if meta == nil {
+		log.Info().Msgf("not found paste with id=%s", id)
+		return nil, ErrNotFound
+	}
+	if common.IsExpired(meta.ExpiresAt) {
+		log.Info().Msgf("paste with id=%s is expired", id)
+		return nil, ErrNotFound
+	}
+	return meta, nil
+}
+
+// Saves part of paste inline, or as asset when it's larger than inline limit
+func (s *Service) savePart(ctx context.Context, part string) (inline, assetId string, err error) {
+	if len(part) <= InlineMaxSize {
+		return part, "", nil
+	}
+	asset, err := s.assetStorage.SaveAssetStream(ctx, BucketName, strings.NewReader(part), int64(len(part)))
+	if err != nil {
+		return "", "", err
+	}
+	return "", asset.Id, nil
+}
+
+func (s *Service) readPart(ctx context.Context, inline, assetId string) (string, error) {
+	if assetId == "" {
+		return inline, nil
+	}
+	partBytes, err := s.assetStorage.GetAssetBytes(ctx, BucketName, assetId)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.ToValidUTF8(partBytes, []byte("�"))), nil
+}
+
+func newPasteDTO(meta *PasteMetadataDTO) *PasteDTO {
+	return &PasteDTO{
+		Id:        meta.Id,
+		Title:     meta.Title,
+		Language:  meta.Language,
+		Size:      meta.Size,
+		ExpiresAt: meta.ExpiresAt,
+		CreatedAt: meta.CreatedAt,
+	}
+}
@@ -0,0 +1,14 @@
+package uploads
+
+import (
+	"context"
+	"time"
+)
+
+type Repo interface {
+	SaveUpload(ctx context.Context, upload UploadDTO, ttl time.Duration) error
+	GetUpload(ctx context.Context, id string) (*UploadDTO, error)
+	DeleteUpload(ctx context.Context, id string) error
+	LockUpload(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	UnlockUpload(ctx context.Context, id string) error
+}
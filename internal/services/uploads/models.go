@@ -0,0 +1,26 @@
+package uploads
+
+import (
+	"shorty/internal/services/assets"
+	"shorty/internal/services/files"
+	"time"
+)
+
+type UploadDTO struct {
+	Id        string
+	Name      string
+	Length    int64
+	Options   files.FileOptions // options of resulting file
+	Multipart assets.MultipartDTO
+	AssetId   string // asset of completed multipart, file is created from it
+	FileId    string
+	ExpiresAt time.Time
+}
+
+func (u *UploadDTO) Offset() int64 {
+	return u.Multipart.Offset
+}
+
+func (u *UploadDTO) Finished() bool {
+	return u.FileId != ""
+}
@@ -0,0 +1,313 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"io"
+	"shorty/internal/common"
+	"shorty/internal/common/logging"
+	"shorty/internal/common/metrics"
+	"shorty/internal/services/assets"
+	"shorty/internal/services/files"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrInternal       = errors.New("internal error")
+	ErrNotFound       = errors.New("upload not found")
+	ErrTooBig         = errors.New("upload too big")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrLocked         = errors.New("upload is locked by another request")
+	ErrFinished       = errors.New("upload is already finished")
+	ErrOptions        = errors.New("invalid upload options")
+)
+
+const (
+	DefaultMaxSize = 1024 * 1024 * 1024
+
+	UploadTTL = assets.MultipartTTL
+	// Upper bound of single chunk write, lock is released earlier when write ends
+	LockTTL = 15 * time.Minute
+)
+
+func NewService(repo Repo, assetsStorage *assets.Storage, fileService *files.Service, maxSize int64, log logging.Logger, tracer trace.Tracer, meter metrics.Meter) *Service {
+	return &Service{
+		log:              log.WithService("uploads"),
+		tracer:           tracer,
+		repo:             repo,
+		assetStorage:     assetsStorage,
+		fileService:      fileService,
+		maxSize:          maxSize,
+		createdCounter:   meter.NewCounter("uploads_created", "Count of created resumable uploads"),
+		finishedCounter:  meter.NewCounter("uploads_finished", "Count of finished resumable uploads"),
+		terminateCounter: meter.NewCounter("uploads_terminated", "Count of terminated resumable uploads"),
+	}
+}
+
+// Resumable uploads, chunks are appended to S3 multipart upload and
+// finished upload becomes a regular file
+type Service struct {
+	log          logging.Logger
+	tracer       trace.Tracer
+	repo         Repo
+	assetStorage *assets.Storage
+	fileService  *files.Service
+	maxSize      int64
+
+	createdCounter   metrics.Counter
+	finishedCounter  metrics.Counter
+	terminateCounter metrics.Counter
+}
+
+func (s *Service) MaxSize() int64 {
+	return s.maxSize
+}
+
+func (s *Service) CreateUpload(ctx context.Context, name string, length int64, opts files.FileOptions) (*UploadDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "uploads::CreateUpload")
+	defer span.End()
+
+	if length > s.maxSize {
+		log.Info().Msgf("rejected too big upload with length %d", length)
+		return nil, ErrTooBig
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, ErrOptions
+	}
+
+	multipart, err := s.assetStorage.StartMultipart(ctx, files.BucketName)
+	if err != nil {
+		log.Error().Err(err).Msg("failed starting multipart upload")
+		return nil, ErrInternal
+	}
+
+	upload := &UploadDTO{
+		Id:        common.NewShortId(32),
+		Name:      name,
+		Length:    length,
+		Options:   opts,
+		Multipart: *multipart,
+		ExpiresAt: time.Now().Add(UploadTTL),
+	}
+	if err := s.repo.SaveUpload(ctx, *upload, UploadTTL); err != nil {
+		log.Error().Err(err).Msg("failed saving upload")
+		return nil, ErrInternal
+	}
+
+	log.Info().Msgf("created upload with id=%s, length=%d", upload.Id, length)
+	s.createdCounter.Inc()
+
+	// empty upload never receives chunks
+	if length == 0 {
+		if err := s.finish(ctx, upload); err != nil {
+			return nil, err
+		}
+	}
+
+	return upload, nil
+}
+
+func (s *Service) GetUpload(ctx context.Context, id string) (*UploadDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "uploads::GetUpload")
+	defer span.End()
+
+	upload, err := s.repo.GetUpload(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed getting upload (id=%s)", id)
+		return nil, ErrInternal
+	}
+	if upload == nil {
+		log.Info().Msgf("not found upload with id=%s", id)
+		return nil, ErrNotFound
+	}
+
+	return upload, nil
+}
+
+func (s *Service) lock(ctx context.Context, id string) (func(), error) {
+	log := s.log.WithContext(ctx)
+
+	locked, err := s.repo.LockUpload(ctx, id, LockTTL)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed locking upload (id=%s)", id)
+		return nil, ErrInternal
+	}
+	if !locked {
+		return nil, ErrLocked
+	}
+
+	return func() {
+		if err := s.repo.UnlockUpload(ctx, id); err != nil {
+			log.Error().Err(err).Msgf("failed unlocking upload (id=%s)", id)
+		}
+	}, nil
+}
+
+// Appends chunk at given offset. When the last chunk is written, upload is
+// finished into a file. Upload state is saved even if chunk is interrupted,
+// so client can resume from the persisted offset
+func (s *Service) WriteChunk(ctx context.Context, id string, offset int64, r io.Reader) (*UploadDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "uploads::WriteChunk")
+	defer span.End()
+
+	unlock, err := s.lock(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	upload, err := s.GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Finished() {
+		return nil, ErrFinished
+	}
+	if offset != upload.Offset() {
+		log.Info().Msgf("upload (id=%s) offset mismatch, expected=%d, got=%d", id, upload.Offset(), offset)
+		return nil, ErrOffsetMismatch
+	}
+
+	writeErr := s.assetStorage.WriteMultipart(ctx, &upload.Multipart, io.LimitReader(r, upload.Length-offset))
+	tooBig := false
+	if writeErr == nil {
+		// any byte left after upload length means chunk exceeds it
+		n, _ := io.ReadFull(r, make([]byte, 1))
+		tooBig = n > 0
+	}
+
+	if err := s.repo.SaveUpload(ctx, *upload, time.Until(upload.ExpiresAt)); err != nil {
+		log.Error().Err(err).Msgf("failed saving upload (id=%s)", id)
+		return nil, ErrInternal
+	}
+	if writeErr != nil {
+		log.Error().Err(writeErr).Msgf("failed writing upload (id=%s) chunk, offset=%d", id, upload.Offset())
+		return nil, ErrInternal
+	}
+	if tooBig {
+		log.Info().Msgf("upload (id=%s) chunk exceeds upload length", id)
+		return nil, ErrTooBig
+	}
+
+	log.Info().Msgf("written upload (id=%s) chunk, offset=%d", id, upload.Offset())
+
+	if upload.Offset() == upload.Length {
+		if err := s.finish(ctx, upload); err != nil {
+			return nil, err
+		}
+	}
+
+	return upload, nil
+}
+
+func (s *Service) finish(ctx context.Context, upload *UploadDTO) error {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "uploads::finish")
+	defer span.End()
+
+	asset, err := s.completeMultipart(ctx, upload)
+	if err != nil {
+		return err
+	}
+
+	file, err := s.fileService.CreateFile(ctx, upload.Name, asset, upload.Options)
+	if err == files.ErrAssetDeleted {
+		// multipart was dropped in favor of deleted duplicate, so upload has to be started again
+		log.Warning().Msgf("asset of upload (id=%s) is deleted, dropping upload", upload.Id)
+		if err := s.repo.DeleteUpload(ctx, upload.Id); err != nil {
+			log.Error().Err(err).Msgf("failed deleting upload (id=%s)", upload.Id)
+		}
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	upload.FileId = file.Id
+	if err := s.repo.SaveUpload(ctx, *upload, time.Until(upload.ExpiresAt)); err != nil {
+		log.Error().Err(err).Msgf("failed saving finished upload (id=%s)", upload.Id)
+		return ErrInternal
+	}
+
+	log.Info().Msgf("finished upload (id=%s) into file (id=%s)", upload.Id, file.Id)
+	s.finishedCounter.Inc()
+
+	return nil
+}
+
+// Completed multipart can't be completed again, so its asset is persisted before file
+// is created from it, and finishing is retried from the persisted asset
+func (s *Service) completeMultipart(ctx context.Context, upload *UploadDTO) (*assets.AssetMetadataDTO, error) {
+	log := s.log.WithContext(ctx)
+
+	if upload.AssetId != "" {
+		asset, err := s.assetStorage.GetAssetMetadata(ctx, upload.AssetId)
+		if err != nil || asset == nil {
+			log.Error().Err(err).Msgf("failed getting completed upload (id=%s) asset", upload.Id)
+			return nil, ErrInternal
+		}
+		return asset, nil
+	}
+
+	asset, err := s.assetStorage.CompleteMultipart(ctx, &upload.Multipart)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed completing upload (id=%s)", upload.Id)
+		return nil, ErrInternal
+	}
+
+	upload.AssetId = asset.Id
+	if err := s.repo.SaveUpload(ctx, *upload, time.Until(upload.ExpiresAt)); err != nil {
+		log.Error().Err(err).Msgf("failed saving completed upload (id=%s)", upload.Id)
+		return nil, ErrInternal
+	}
+	return asset, nil
+}
+
+func (s *Service) TerminateUpload(ctx context.Context, id string) error {
+	log := s.log.WithContext(ctx)
+
+	ctx, span := s.tracer.Start(ctx, "uploads::TerminateUpload")
+	defer span.End()
+
+	unlock, err := s.lock(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	upload, err := s.GetUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	if upload.Finished() {
+		return ErrFinished
+	}
+
+	if upload.AssetId != "" {
+		// completed asset may be shared by deduplication, so it is deleted only when unreferenced
+		if err := s.assetStorage.ReleaseAssets(ctx, upload.AssetId); err != nil {
+			return ErrInternal
+		}
+	} else if err := s.assetStorage.AbortMultipart(ctx, &upload.Multipart); err != nil {
+		log.Error().Err(err).Msgf("failed aborting upload (id=%s)", id)
+		return ErrInternal
+	}
+	if err := s.repo.DeleteUpload(ctx, id); err != nil {
+		log.Error().Err(err).Msgf("failed deleting upload (id=%s)", id)
+		return ErrInternal
+	}
+
+	log.Info().Msgf("terminated upload (id=%s)", id)
+	s.terminateCounter.Inc()
+
+	return nil
+}
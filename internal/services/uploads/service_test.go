@@ -0,0 +1,182 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"shorty/internal/common/logging"
+	"shorty/internal/common/metrics"
+	"shorty/internal/common/scanner"
+	"shorty/internal/databases/blob"
+	"shorty/internal/services/assets"
+	"shorty/internal/services/assets/assetstest"
+	"shorty/internal/services/files"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type memoryRepo struct {
+	uploads map[string]UploadDTO
+	locks   map[string]bool
+}
+
+func (r *memoryRepo) SaveUpload(ctx context.Context, upload UploadDTO, ttl time.Duration) error {
+	r.uploads[upload.Id] = upload
+	return nil
+}
+
+func (r *memoryRepo) GetUpload(ctx context.Context, id string) (*UploadDTO, error) {
+	upload, ok := r.uploads[id]
+	if !ok {
+		return nil, nil
+	}
+	return &upload, nil
+}
+
+func (r *memoryRepo) DeleteUpload(ctx context.Context, id string) error {
+	delete(r.uploads, id)
+	return nil
+}
+
+func (r *memoryRepo) LockUpload(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	if r.locks[id] {
+		return false, nil
+	}
+	r.locks[id] = true
+	return true, nil
+}
+
+func (r *memoryRepo) UnlockUpload(ctx context.Context, id string) error {
+	delete(r.locks, id)
+	return nil
+}
+
+// Files repository, which fails saving given number of times
+type memoryFiles struct {
+	files.MetadataRepo
+	files []files.FileMetadataDTO
+	fails int
+}
+
+func (r *memoryFiles) SaveFileMetadata(ctx context.Context, meta files.FileMetadataDTO) (bool, error) {
+	if r.fails > 0 {
+		r.fails--
+		return false, errors.New("database is down")
+	}
+	r.files = append(r.files, meta)
+	return true, nil
+}
+
+type testEnv struct {
+	service *Service
+	repo    *memoryRepo
+	assets  *assetstest.Repo
+	files   *memoryFiles
+}
+
+func newTestEnv(t *testing.T, maxSize int64) *testEnv {
+	t.Helper()
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := noop.NewTracerProvider().Tracer("test")
+	meter := metrics.NewNoop()
+
+	env := &testEnv{
+		repo:   &memoryRepo{uploads: map[string]UploadDTO{}, locks: map[string]bool{}},
+		assets: assetstest.NewRepo(),
+		files:  &memoryFiles{},
+	}
+	storage := assets.NewStorage(env.assets, assetstest.Cache{}, blob.NewMemory(), nil, nil, logger, tracer)
+	fileService := files.NewService(env.files, storage, &assetstest.Broker{}, scanner.NewChecker(nil, scanner.FailOpen, 0), nil, logger, tracer, meter)
+	env.service = NewService(env.repo, storage, fileService, maxSize, logger, tracer, meter)
+	return env
+}
+
+func TestWriteChunkOffset(t *testing.T) {
+	env := newTestEnv(t, 1024)
+	ctx := context.Background()
+
+	upload, err := env.service.CreateUpload(ctx, "notes.txt", 10, files.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.service.WriteChunk(ctx, upload.Id, 5, strings.NewReader("world")); err != ErrOffsetMismatch {
+		t.Fatalf("expected offset mismatch, got %v", err)
+	}
+
+	upload, err = env.service.WriteChunk(ctx, upload.Id, 0, strings.NewReader("hello"))
+	if err != nil || upload.Offset() != 5 || upload.Finished() {
+		t.Fatalf("expected unfinished upload at offset 5, got %d, %v", upload.Offset(), err)
+	}
+	// chunk already written is rejected, so it isn't appended twice
+	if _, err := env.service.WriteChunk(ctx, upload.Id, 0, strings.NewReader("hello")); err != ErrOffsetMismatch {
+		t.Fatalf("expected offset mismatch, got %v", err)
+	}
+
+	upload, err = env.service.WriteChunk(ctx, upload.Id, 5, strings.NewReader("world"))
+	if err != nil || !upload.Finished() {
+		t.Fatalf("expected finished upload, got %v", err)
+	}
+	if len(env.files.files) != 1 || env.assets.Statuses[upload.AssetId] != assets.AssetCreated {
+		t.Fatal("expected file with created asset")
+	}
+	if asset := env.assets.Metas[upload.AssetId]; asset.Size != 10 {
+		t.Fatalf("expected asset of 10 bytes, got %d", asset.Size)
+	}
+
+	if _, err := env.service.WriteChunk(ctx, upload.Id, 10, strings.NewReader("")); err != ErrFinished {
+		t.Fatalf("expected finished error, got %v", err)
+	}
+}
+
+func TestWriteChunkTooBig(t *testing.T) {
+	env := newTestEnv(t, 8)
+	ctx := context.Background()
+
+	if _, err := env.service.CreateUpload(ctx, "big.bin", 9, files.FileOptions{}); err != ErrTooBig {
+		t.Fatalf("expected too big error, got %v", err)
+	}
+
+	upload, err := env.service.CreateUpload(ctx, "small.bin", 5, files.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.service.WriteChunk(ctx, upload.Id, 0, strings.NewReader("hello world")); err != ErrTooBig {
+		t.Fatalf("expected too big error, got %v", err)
+	}
+	if upload, _ := env.service.GetUpload(ctx, upload.Id); upload.Finished() {
+		t.Fatal("expected chunk exceeding length not to finish upload")
+	}
+}
+
+func TestFinishRetry(t *testing.T) {
+	env := newTestEnv(t, 1024)
+	ctx := context.Background()
+
+	upload, err := env.service.CreateUpload(ctx, "report.txt", 6, files.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.files.fails = 1
+	if _, err := env.service.WriteChunk(ctx, upload.Id, 0, strings.NewReader("report")); err != files.ErrInternal {
+		t.Fatalf("expected file saving error, got %v", err)
+	}
+	upload, _ = env.service.GetUpload(ctx, upload.Id)
+	if upload.AssetId == "" || upload.Finished() {
+		t.Fatal("expected completed asset persisted before file is created")
+	}
+
+	// multipart is already completed, so retry creates file from persisted asset
+	upload, err = env.service.WriteChunk(ctx, upload.Id, 6, strings.NewReader(""))
+	if err != nil || !upload.Finished() {
+		t.Fatalf("expected finished upload on retry, got %v", err)
+	}
+	if len(env.files.files) != 1 || env.files.files[0].FileId != upload.AssetId {
+		t.Fatal("expected file created from persisted asset")
+	}
+}
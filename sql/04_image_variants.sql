@@ -0,0 +1,7 @@
+create table if not exists image_variants (
+    source_id char(32) references assets(id) not null,
+    format varchar(16) not null,
+    asset_id char(32) references assets(id) not null,
+    created_at timestamp not null default now(),
+    primary key (source_id, format)
+);
@@ -0,0 +1,4 @@
+create type images_status as enum ('processing', 'ready');
+
+alter table images alter column thumbnail_id drop not null;
+alter table images add column if not exists status images_status not null default 'ready';
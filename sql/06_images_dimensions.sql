@@ -0,0 +1,4 @@
+alter table images add column if not exists width integer not null default 0;
+alter table images add column if not exists height integer not null default 0;
+alter table images add column if not exists format varchar(16) not null default 'jpeg';
+alter table images add column if not exists placeholder text not null default '';
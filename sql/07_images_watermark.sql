@@ -0,0 +1,2 @@
+alter table images add column if not exists watermark boolean not null default false;
+alter table images add column if not exists watermarked_id char(32) references assets(id);
@@ -0,0 +1 @@
+alter table images add column if not exists source_id char(32) references images(id);
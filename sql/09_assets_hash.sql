@@ -0,0 +1 @@
+create index if not exists idx_assets_hash on assets using hash(hash);
@@ -0,0 +1,5 @@
+alter table files add column if not exists expires_at timestamp;
+alter table images add column if not exists expires_at timestamp;
+
+create index if not exists idx_files_expires_at on files(expires_at) where expires_at is not null;
+create index if not exists idx_images_expires_at on images(expires_at) where expires_at is not null;
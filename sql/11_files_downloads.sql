@@ -0,0 +1,2 @@
+alter table files add column if not exists max_downloads integer;
+alter table files add column if not exists downloads integer not null default 0;
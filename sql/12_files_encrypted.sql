@@ -0,0 +1 @@
+alter table files add column if not exists encrypted boolean not null default false;
@@ -0,0 +1,4 @@
+alter table assets add column if not exists key_id varchar(64);
+alter table assets add column if not exists data_key bytea;
+
+create index if not exists idx_assets_key_id on assets(key_id) where key_id is not null;
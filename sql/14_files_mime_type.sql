@@ -0,0 +1 @@
+alter table files add column if not exists mime_type varchar(255) not null default 'application/octet-stream';
@@ -0,0 +1,9 @@
+create table if not exists bundles (
+    id char(32) primary key,
+    expires_at timestamp,
+    created_at timestamp not null default now()
+);
+
+alter table files add column if not exists bundle_id char(32) references bundles(id);
+
+create index if not exists idx_files_bundle_id on files(bundle_id) where bundle_id is not null;
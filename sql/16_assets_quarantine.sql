@@ -0,0 +1 @@
+alter type assets_status add value if not exists 'quarantined';
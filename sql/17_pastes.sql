@@ -0,0 +1,12 @@
+create table if not exists pastes (
+    id char(16) primary key,
+    title varchar(256) not null default '',
+    language varchar(64) not null,
+    content text,
+    asset_id char(32) references assets(id),
+    size integer not null,
+    expires_at timestamp,
+    created_at timestamp not null default now()
+);
+
+create index if not exists idx_pastes_expires_at on pastes(expires_at) where expires_at is not null;
@@ -0,0 +1,7 @@
+-- asset references are counted before deleting asset object, deduplicated assets are shared
+create index if not exists idx_files_file_id on files(file_id);
+create index if not exists idx_images_original_id on images(original_id);
+create index if not exists idx_images_thumbnail_id on images(thumbnail_id) where thumbnail_id is not null;
+create index if not exists idx_images_watermarked_id on images(watermarked_id) where watermarked_id is not null;
+create index if not exists idx_image_variants_asset_id on image_variants(asset_id);
+create index if not exists idx_pastes_asset_id on pastes(asset_id) where asset_id is not null;
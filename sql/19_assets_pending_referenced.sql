@@ -0,0 +1,14 @@
+-- batch status update of earlier releases left assets saved together pending, though their
+-- objects exist and records reference them. They are marked created before pending assets
+-- janitor is started, references are the same as in asset references query of the app
+with refs as (
+    select file_id as asset_id from files
+    union all select original_id from images
+    union all select thumbnail_id from images where thumbnail_id is not null
+    union all select watermarked_id from images where watermarked_id is not null
+    union all select v.asset_id from image_variants v
+        join images i on v.source_id in (i.original_id, i.thumbnail_id, i.watermarked_id)
+    union all select asset_id from pastes where asset_id is not null
+)
+update assets set status = 'created', updated_at = now()
+where status = 'pending' and id in (select asset_id from refs);
@@ -0,0 +1,2 @@
+-- pending asset of multipart upload is inserted on upload start, so parts of abandoned upload can be found
+alter table assets add column if not exists upload_id varchar(256);
@@ -0,0 +1,3 @@
+-- assets saved before scanning was recorded, or while scanner was disabled or unavailable, are unscanned
+-- and duplicates of them are scanned again
+alter table assets add column if not exists scanned boolean not null default false;
@@ -0,0 +1,3 @@
+-- preview is created once by preview worker, failed preview isn't created again
+alter table files add column if not exists preview_id char(32) references assets(id);
+alter table files add column if not exists preview_failed boolean not null default false;
@@ -0,0 +1,4 @@
+-- image, which can't be processed, is marked failed instead of staying processing forever
+alter type images_status add value if not exists 'failed';
+
+create index if not exists idx_images_processing on images(updated_at) where status = 'processing';
@@ -0,0 +1,5 @@
+-- paste is highlighted once on creation, html is stored like content: inline or as asset
+alter table pastes add column if not exists highlighted text;
+alter table pastes add column if not exists highlighted_id char(32) references assets(id);
+
+create index if not exists idx_pastes_highlighted_id on pastes(highlighted_id) where highlighted_id is not null;
@@ -0,0 +1,4 @@
+-- dimensions of images uploaded before they were stored are unknown, they were left zero
+alter table images alter column width drop not null, alter column width drop default;
+alter table images alter column height drop not null, alter column height drop default;
+update images set width = null, height = null where width = 0 or height = 0;